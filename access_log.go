@@ -0,0 +1,273 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigawattio/web/auth"
+)
+
+// AccessLogFormat selects the line format written by AccessLogMiddleware.
+type AccessLogFormat int
+
+const (
+	AccessLogCommon   AccessLogFormat = iota // Apache common log format.
+	AccessLogCombined                        // Apache combined log format.
+	AccessLogLogfmt                          // key=value pairs.
+	AccessLogJson                            // One JSON object per line.
+)
+
+// Access log field names, usable in AccessLogOptions.Fields.  Request headers
+// may also be included by naming them as "header:<Name>".
+const (
+	AccessLogFieldTime       = "time"
+	AccessLogFieldRemoteAddr = "remoteAddr"
+	AccessLogFieldUser       = "user" // only once verified by auth.BasicAuth.
+	AccessLogFieldMethod     = "method"
+	AccessLogFieldUri        = "uri"
+	AccessLogFieldProto      = "proto"
+	AccessLogFieldHost       = "host"
+	AccessLogFieldStatus     = "status"
+	AccessLogFieldBytes      = "bytes"
+	AccessLogFieldDuration   = "durationMs"
+	AccessLogFieldReferer    = "referer"
+	AccessLogFieldUserAgent  = "userAgent"
+	AccessLogFieldRequestId  = "requestId"
+)
+
+// DefaultAccessLogFields are written by the logfmt and JSON formats when no
+// fields are configured.
+var DefaultAccessLogFields = []string{
+	AccessLogFieldTime,
+	AccessLogFieldRemoteAddr,
+	AccessLogFieldMethod,
+	AccessLogFieldUri,
+	AccessLogFieldStatus,
+	AccessLogFieldBytes,
+	AccessLogFieldDuration,
+	AccessLogFieldRequestId,
+}
+
+// DefaultRedactedHeaders are the request headers whose values are masked when
+// no redaction list is configured.
+var DefaultRedactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+const redacted = "[REDACTED]"
+
+// AccessLogOptions configures AccessLogMiddleware.
+type AccessLogOptions struct {
+	Format          AccessLogFormat
+	Writer          io.Writer // Sink for log lines, defaults to os.Stderr.  See also RotatingFile.
+	Fields          []string  // Fields for logfmt and JSON formats, defaults to DefaultAccessLogFields.
+	RedactedHeaders []string  // Header fields whose values are masked, defaults to DefaultRedactedHeaders.
+	SampleRate      float64   // Fraction of requests to log; 0 means all.  Server errors are always logged.
+}
+
+// AccessLogMiddleware generates a middleware function which writes one line
+// per request to the configured writer once the response has been served.
+func AccessLogMiddleware(options AccessLogOptions) MiddlewareFunc {
+	if options.Writer == nil {
+		options.Writer = os.Stderr
+	}
+	if len(options.Fields) == 0 {
+		options.Fields = DefaultAccessLogFields
+	}
+	if options.RedactedHeaders == nil {
+		options.RedactedHeaders = DefaultRedactedHeaders
+	}
+	redactions := map[string]struct{}{}
+	for _, name := range options.RedactedHeaders {
+		redactions[http.CanonicalHeaderKey(name)] = struct{}{}
+	}
+	var lock sync.Mutex // Serializes writes to options.Writer.
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			tw := NewTrackingResponseWriter(w)
+			req = auth.TrackAuthenticatedUser(req)
+			next.ServeHTTP(tw, req)
+
+			status := tw.Status()
+			if status == 0 {
+				status = http.StatusOK // net/http's implicit status.
+			}
+			if options.SampleRate > 0 && options.SampleRate < 1 && status < 500 && rand.Float64() >= options.SampleRate {
+				return
+			}
+			entry := &accessLogEntry{
+				req:        req,
+				start:      start,
+				duration:   time.Since(start),
+				status:     status,
+				bytes:      tw.BytesWritten(),
+				redactions: redactions,
+			}
+			var line []byte
+			switch options.Format {
+			case AccessLogCombined:
+				line = entry.apache(true)
+			case AccessLogLogfmt:
+				line = entry.logfmt(options.Fields)
+			case AccessLogJson:
+				line = entry.json(options.Fields)
+			default:
+				line = entry.apache(false)
+			}
+			lock.Lock()
+			_, err := options.Writer.Write(line)
+			lock.Unlock()
+			if err != nil {
+				RequestLogger(req).Errorf("web.AccessLogMiddleware: error writing access log: %s", err)
+			}
+		})
+	}
+}
+
+type accessLogEntry struct {
+	req        *http.Request
+	start      time.Time
+	duration   time.Duration
+	status     int
+	bytes      int64
+	redactions map[string]struct{}
+}
+
+func (entry *accessLogEntry) remoteHost() string {
+	return ClientIp(entry.req)
+}
+
+// user is only known once verified by auth.BasicAuth, since the client
+// controls the Authorization header.
+func (entry *accessLogEntry) user() string {
+	user, _ := auth.AuthenticatedUser(entry.req)
+	return user
+}
+
+// apache renders the common, or when combined is true the combined, format.
+func (entry *accessLogEntry) apache(combined bool) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s - %s [%s] \"%s %s %s\" %d %s",
+		dash(entry.remoteHost()),
+		dash(entry.user()),
+		entry.start.Format("02/Jan/2006:15:04:05 -0700"),
+		entry.req.Method,
+		entry.req.RequestURI,
+		entry.req.Proto,
+		entry.status,
+		dash(entry.bytesString()),
+	)
+	if combined {
+		fmt.Fprintf(buf, " %q %q", entry.header("Referer"), entry.header("User-Agent"))
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func (entry *accessLogEntry) bytesString() string {
+	if entry.bytes == 0 {
+		return ""
+	}
+	return strconv.FormatInt(entry.bytes, 10)
+}
+
+func (entry *accessLogEntry) header(name string) string {
+	name = http.CanonicalHeaderKey(name)
+	value := entry.req.Header.Get(name)
+	if _, ok := entry.redactions[name]; ok && len(value) > 0 {
+		return redacted
+	}
+	return value
+}
+
+// value resolves a single field, returning nil for unknown fields.
+func (entry *accessLogEntry) value(field string) interface{} {
+	switch field {
+	case AccessLogFieldTime:
+		return entry.start.Format(time.RFC3339Nano)
+	case AccessLogFieldRemoteAddr:
+		return entry.remoteHost()
+	case AccessLogFieldUser:
+		return entry.user()
+	case AccessLogFieldMethod:
+		return entry.req.Method
+	case AccessLogFieldUri:
+		return entry.req.RequestURI
+	case AccessLogFieldProto:
+		return entry.req.Proto
+	case AccessLogFieldHost:
+		return entry.req.Host
+	case AccessLogFieldStatus:
+		return entry.status
+	case AccessLogFieldBytes:
+		return entry.bytes
+	case AccessLogFieldDuration:
+		return float64(entry.duration) / float64(time.Millisecond)
+	case AccessLogFieldReferer:
+		return entry.header("Referer")
+	case AccessLogFieldUserAgent:
+		return entry.header("User-Agent")
+	case AccessLogFieldRequestId:
+		return RequestId(entry.req)
+	}
+	if strings.HasPrefix(field, "header:") {
+		return entry.header(field[len("header:"):])
+	}
+	return nil
+}
+
+func (entry *accessLogEntry) logfmt(fields []string) []byte {
+	buf := &bytes.Buffer{}
+	for i, field := range fields {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(field)
+		buf.WriteByte('=')
+		var s string
+		switch v := entry.value(field).(type) {
+		case nil:
+		case string:
+			s = v
+		case float64:
+			s = strconv.FormatFloat(v, 'f', 3, 64)
+		default:
+			s = fmt.Sprint(v)
+		}
+		if len(s) == 0 || strings.ContainsAny(s, " =\"\t\n") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func (entry *accessLogEntry) json(fields []string) []byte {
+	m := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		m[field] = entry.value(field)
+	}
+	line, err := json.Marshal(m)
+	if err != nil {
+		logger.Errorf("web.AccessLogMiddleware: error marshalling access log entry: %s", err)
+		return nil
+	}
+	return append(line, '\n')
+}
+
+func dash(s string) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return s
+}
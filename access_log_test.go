@@ -0,0 +1,205 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/web/auth"
+)
+
+func serveAccessLogged(t *testing.T, options AccessLogOptions) string {
+	buf := &bytes.Buffer{}
+	options.Writer = buf
+	handler := AccessLogMiddleware(options)(auth.SimpleBasicAuth("jay", "secret")(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		RespondWithText(w, http.StatusCreated, "hello")
+	})))
+	req, _ := http.NewRequest("POST", "http://example.com/things?x=1", nil)
+	req.RequestURI = "/things?x=1"
+	req.RemoteAddr = "10.1.2.3:5555"
+	req.SetBasicAuth("jay", "secret")
+	req.Header.Set("Referer", "http://example.com/")
+	req.Header.Set("User-Agent", "test agent")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	return buf.String()
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	testCases := []struct {
+		options  AccessLogOptions
+		expected *regexp.Regexp
+	}{
+		{
+			AccessLogOptions{Format: AccessLogCommon},
+			regexp.MustCompile(`^10\.1\.2\.3 - jay \[[^\]]+\] "POST /things\?x=1 HTTP/1\.1" 201 5\n$`),
+		},
+		{
+			AccessLogOptions{Format: AccessLogCombined},
+			regexp.MustCompile(`^10\.1\.2\.3 - jay \[[^\]]+\] "POST /things\?x=1 HTTP/1\.1" 201 5 "http://example\.com/" "test agent"\n$`),
+		},
+		{
+			AccessLogOptions{Format: AccessLogLogfmt, Fields: []string{"method", "status", "bytes", "userAgent", "header:Authorization", "requestId"}},
+			regexp.MustCompile(`^method=POST status=201 bytes=5 userAgent="test agent" header:Authorization=\[REDACTED\] requestId=""\n$`),
+		},
+	}
+	for i, testCase := range testCases {
+		if actual := serveAccessLogged(t, testCase.options); !testCase.expected.MatchString(actual) {
+			t.Errorf("[i=%v] Expected access log line to match %s but actual=%q", i, testCase.expected, actual)
+		}
+	}
+}
+
+func TestAccessLogJson(t *testing.T) {
+	line := serveAccessLogged(t, AccessLogOptions{Format: AccessLogJson})
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("Failed to parse JSON access log line=%q: %s", line, err)
+	}
+	for _, field := range DefaultAccessLogFields {
+		if _, ok := entry[field]; !ok {
+			t.Errorf("Expected field %q in JSON access log line=%q", field, line)
+		}
+	}
+	if expected, actual := float64(201), entry["status"]; actual != expected {
+		t.Errorf("Expected status=%v but actual=%v", expected, actual)
+	}
+}
+
+// TestAccessLogUnverifiedUser ensures credentials which no auth middleware
+// has checked don't make it into the log.
+func TestAccessLogUnverifiedUser(t *testing.T) {
+	buf := &bytes.Buffer{}
+	handler := AccessLogMiddleware(AccessLogOptions{Writer: buf})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RequestURI = "/"
+	req.SetBasicAuth("admin", "anything")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if expected := regexp.MustCompile(`^\S+ - - \[`); !expected.MatchString(buf.String()) {
+		t.Errorf("Expected access log line to match %s but actual=%q", expected, buf.String())
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	buf := &bytes.Buffer{}
+	status := http.StatusOK
+	handler := AccessLogMiddleware(AccessLogOptions{Writer: buf, SampleRate: 0.000001})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(status)
+	}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if buf.Len() != 0 {
+		t.Errorf("Expected sampled out request to not be logged but found %q", buf.String())
+	}
+	status = http.StatusBadGateway
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if !strings.Contains(buf.String(), " 502 ") {
+		t.Errorf("Expected server errors to always be logged but found %q", buf.String())
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotating-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "access.log")
+	rf, err := NewRotatingFile(filename, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		filename:        "dddddd\n",
+		filename + ".1": "cccccc\n",
+		filename + ".2": "bbbbbb\n",
+	}
+	for name, content := range expected {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("Expected %s content=%q but actual=%q", name, content, string(data))
+		}
+	}
+	if _, err := os.Stat(filename + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected no more than 2 backups but found %s.3 (err=%v)", filename, err)
+	}
+}
+
+func TestRotatingFileRotationFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotating-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "access.log")
+	// A non-empty directory in the way of the backup makes the rename fail.
+	if err := os.MkdirAll(filepath.Join(filename+".1", "blocker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := NewRotatingFile(filename, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatalf("Expected writes to continue despite failed rotation but got error: %s", err)
+		}
+	}
+	if err := rf.Rotate(); err == nil {
+		t.Errorf("Expected forced rotation to report the failure")
+	}
+	if _, err := rf.Write([]byte("dddddd\n")); err != nil {
+		t.Fatalf("Expected writes to continue after failed forced rotation but got error: %s", err)
+	}
+
+	// Rotation isn't retried on every write, only once the interval is up.
+	if err := os.RemoveAll(filename + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.Write([]byte("eeeeee\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename + ".1"); !os.IsNotExist(err) {
+		t.Errorf("Expected no rotation before the retry interval is up but stat err=%v", err)
+	}
+	rf.retryAt = time.Now()
+	if _, err := rf.Write([]byte("ffffff\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		filename + ".1": "aaaaaa\nbbbbbb\ncccccc\ndddddd\neeeeee\n",
+		filename:        "ffffff\n",
+	} {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := string(data); actual != expected {
+			t.Errorf("Expected %s content=%q but actual=%q", name, expected, actual)
+		}
+	}
+	if _, err := rf.Write([]byte("gggggg\n")); err != RotatingFileClosedError {
+		t.Errorf("Expected RotatingFileClosedError after Close but actual=%v", err)
+	}
+}
@@ -0,0 +1,222 @@
+// Package admin provides a debug and administration web server, intended to
+// be bound separately from the main service, typically on localhost.
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/debug"
+	rpprof "runtime/pprof"
+	"time"
+
+	"github.com/gigawattio/web"
+	"github.com/gigawattio/web/auth"
+	"github.com/gigawattio/web/logging"
+	"github.com/gigawattio/web/metrics"
+	"github.com/gigawattio/web/route"
+)
+
+// DefaultAddr is where the admin server listens when Options.Addr is empty.
+const DefaultAddr = "127.0.0.1:6060"
+
+var MissingAuthError = errors.New("admin: Auth is required unless Insecure is set")
+
+// Options configures the admin server.
+type Options struct {
+	Addr     string                   // TCP address to listen on, DefaultAddr if empty.
+	Auth     *auth.AuthOptions        // basic auth credentials, required unless Insecure.
+	Insecure bool                     // serve without authentication, e.g. behind another auth layer.
+	Version  string                   // optional application version, reported by /debug/build.
+	Routes   func() []route.RouteInfo // optional live route table, e.g. a ConfigWatcher's Routes.
+	Metrics  *metrics.Registry        // optional, served at /metrics.
+	Health   *web.HealthRegistry      // optional, served at /healthz, /livez and /readyz.
+}
+
+// Handler serves the admin endpoints:
+//
+//	/debug/pprof/      net/http/pprof profiles
+//	/debug/goroutines  full goroutine dump
+//	/debug/runtime     memory, GC and scheduler stats
+//	/debug/build       module build info
+//	/debug/routes      the live route table, when Options.Routes is set
+//	/debug/log-levels  GET to list; PUT or POST subsystem=...&level=... to set;
+//	                   DELETE subsystem=... to reset to the inherited level
+//
+// along with /metrics and the health endpoints when configured.
+func Handler(options Options) (http.Handler, error) {
+	if options.Auth == nil && !options.Insecure {
+		return nil, MissingAuthError
+	}
+	started := time.Now()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/goroutines", goroutinesHandler)
+	mux.HandleFunc("/debug/runtime", runtimeHandler(started))
+	mux.HandleFunc("/debug/build", buildHandler(options.Version))
+	mux.HandleFunc("/debug/log-levels", logLevelsHandler)
+	if options.Routes != nil {
+		mux.HandleFunc("/debug/routes", func(w http.ResponseWriter, req *http.Request) {
+			web.RespondWithJson(w, http.StatusOK, options.Routes())
+		})
+	}
+	if options.Metrics != nil {
+		mux.Handle("/metrics", options.Metrics.Handler())
+	}
+	if options.Health != nil {
+		mux.HandleFunc("/healthz", options.Health.HealthzHandler())
+		mux.HandleFunc("/livez", options.Health.LivezHandler())
+		mux.HandleFunc("/readyz", options.Health.ReadyzHandler())
+	}
+
+	var handler http.Handler = mux
+	if options.Auth != nil {
+		authOptions := *options.Auth
+		if len(authOptions.Realm) == 0 {
+			authOptions.Realm = "admin"
+		}
+		handler = auth.BasicAuth(authOptions)(handler)
+	}
+	return handler, nil
+}
+
+// NewServer creates, but doesn't start, an admin WebServer.
+func NewServer(options Options) (*web.WebServer, error) {
+	handler, err := Handler(options)
+	if err != nil {
+		return nil, err
+	}
+	if len(options.Addr) == 0 {
+		options.Addr = DefaultAddr
+	}
+	ws := web.NewWebServer(web.WebServerOptions{
+		Addr:    options.Addr,
+		Handler: handler,
+	})
+	return ws, nil
+}
+
+func goroutinesHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rpprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+func runtimeHandler(started time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		hostname, _ := os.Hostname()
+		web.RespondWithJson(w, http.StatusOK, web.Json{
+			"hostname":   hostname,
+			"pid":        os.Getpid(),
+			"uptime":     time.Since(started).String(),
+			"goVersion":  runtime.Version(),
+			"numCpu":     runtime.NumCPU(),
+			"gomaxprocs": runtime.GOMAXPROCS(0),
+			"goroutines": runtime.NumGoroutine(),
+			"cgoCalls":   runtime.NumCgoCall(),
+			"memory": web.Json{
+				"alloc":        stats.Alloc,
+				"totalAlloc":   stats.TotalAlloc,
+				"sys":          stats.Sys,
+				"mallocs":      stats.Mallocs,
+				"frees":        stats.Frees,
+				"heapAlloc":    stats.HeapAlloc,
+				"heapSys":      stats.HeapSys,
+				"heapIdle":     stats.HeapIdle,
+				"heapInuse":    stats.HeapInuse,
+				"heapReleased": stats.HeapReleased,
+				"heapObjects":  stats.HeapObjects,
+				"stackInuse":   stats.StackInuse,
+			},
+			"gc": web.Json{
+				"numGc":        stats.NumGC,
+				"numForcedGc":  stats.NumForcedGC,
+				"pauseTotalNs": stats.PauseTotalNs,
+				"lastPauseNs":  stats.PauseNs[(stats.NumGC+255)%256],
+				"lastGc":       time.Unix(0, int64(stats.LastGC)).UTC(),
+				"nextGc":       stats.NextGC,
+				"cpuFraction":  stats.GCCPUFraction,
+			},
+		})
+	}
+}
+
+func buildHandler(version string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		j := web.Json{
+			"version":   version,
+			"goVersion": runtime.Version(),
+		}
+		if info, ok := debug.ReadBuildInfo(); ok {
+			j["path"] = info.Path
+			j["main"] = moduleJson(&info.Main)
+			deps := make([]web.Json, 0, len(info.Deps))
+			for _, dep := range info.Deps {
+				deps = append(deps, moduleJson(dep))
+			}
+			j["deps"] = deps
+		}
+		web.RespondWithJson(w, http.StatusOK, j)
+	}
+}
+
+func moduleJson(module *debug.Module) web.Json {
+	j := web.Json{
+		"path":    module.Path,
+		"version": module.Version,
+	}
+	if module.Replace != nil {
+		j["replace"] = moduleJson(module.Replace)
+	}
+	return j
+}
+
+func logLevelsHandler(w http.ResponseWriter, req *http.Request) {
+	subsystem := req.FormValue("subsystem")
+	switch req.Method {
+	case "GET", "HEAD":
+	case "PUT", "POST":
+		if len(subsystem) == 0 {
+			web.RespondWithJson(w, http.StatusBadRequest, web.JsonErrorFor(req, "subsystem is required"))
+			return
+		}
+		level, err := logging.ParseLevel(req.FormValue("level"))
+		if err != nil {
+			web.RespondWithJson(w, http.StatusBadRequest, web.JsonErrorFor(req, err))
+			return
+		}
+		logging.SetLevel(subsystem, level)
+		web.RequestLogger(req).Infof("admin: set log level subsystem=%s level=%s", subsystem, level)
+	case "DELETE":
+		if len(subsystem) == 0 {
+			web.RespondWithJson(w, http.StatusBadRequest, web.JsonErrorFor(req, "subsystem is required"))
+			return
+		}
+		logging.ResetLevel(subsystem)
+		web.RequestLogger(req).Infof("admin: reset log level subsystem=%s", subsystem)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+		web.RespondWithJson(w, http.StatusMethodNotAllowed, web.JsonErrorFor(req, http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	levels := logging.Levels()
+	rendered := make(map[string]string, len(levels))
+	for name, level := range levels {
+		rendered[name] = level.String()
+	}
+	j := web.Json{"levels": rendered}
+	if len(subsystem) > 0 {
+		j["subsystem"] = subsystem
+		j["effective"] = logging.LevelOf(subsystem).String()
+	}
+	web.RespondWithJson(w, http.StatusOK, j)
+}
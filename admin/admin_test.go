@@ -0,0 +1,176 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gigawattio/web/auth"
+	"github.com/gigawattio/web/logging"
+	"github.com/gigawattio/web/metrics"
+	"github.com/gigawattio/web/route"
+)
+
+func testHandler(t *testing.T, options Options) http.Handler {
+	handler, err := Handler(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func serve(h http.Handler, method string, target string, user string, password string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if len(user) > 0 {
+		req.SetBasicAuth(user, password)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlerRequiresAuth(t *testing.T) {
+	if _, err := Handler(Options{}); err != MissingAuthError {
+		t.Errorf("Expected err=%v but actual=%v", MissingAuthError, err)
+	}
+	if _, err := NewServer(Options{}); err != MissingAuthError {
+		t.Errorf("Expected err=%v but actual=%v", MissingAuthError, err)
+	}
+	if _, err := Handler(Options{Insecure: true}); err != nil {
+		t.Errorf("Expected no error for an insecure handler but actual=%v", err)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	h := testHandler(t, Options{Auth: &auth.AuthOptions{User: "admin", Password: "secret"}})
+
+	testCases := []struct {
+		user     string
+		password string
+		expected int
+	}{
+		{expected: http.StatusUnauthorized},
+		{user: "admin", password: "wrong", expected: http.StatusUnauthorized},
+		{user: "admin", password: "secret", expected: http.StatusOK},
+	}
+	for i, testCase := range testCases {
+		rec := serve(h, "GET", "/debug/runtime", testCase.user, testCase.password)
+		if rec.Code != testCase.expected {
+			t.Errorf("[i=%v] Expected status-code=%v but actual=%v", i, testCase.expected, rec.Code)
+		}
+		if rec.Code == http.StatusUnauthorized && !strings.Contains(rec.Header().Get("WWW-Authenticate"), `realm="admin"`) {
+			t.Errorf("[i=%v] Expected WWW-Authenticate realm=admin but actual=%q", i, rec.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	registry := metrics.NewRegistry()
+	registry.NewCounterVec("admin_test_total", "Test counter.").With().Inc()
+	h := testHandler(t, Options{
+		Insecure: true,
+		Version:  "1.2.3",
+		Routes: func() []route.RouteInfo {
+			return []route.RouteInfo{{Method: "GET", Path: "/v1/apps"}}
+		},
+		Metrics: registry,
+	})
+
+	testCases := []struct {
+		path     string
+		contains string
+	}{
+		{path: "/debug/pprof/", contains: "goroutine"},
+		{path: "/debug/pprof/cmdline", contains: ""},
+		{path: "/debug/goroutines", contains: "goroutine "},
+		{path: "/debug/runtime", contains: `"heapAlloc"`},
+		{path: "/debug/build", contains: `"version":"1.2.3"`},
+		{path: "/debug/routes", contains: `{"method":"GET","path":"/v1/apps"}`},
+		{path: "/debug/log-levels", contains: `"levels"`},
+		{path: "/metrics", contains: "admin_test_total 1"},
+	}
+	for i, testCase := range testCases {
+		rec := serve(h, "GET", testCase.path, "", "")
+		if rec.Code != http.StatusOK {
+			t.Errorf("[i=%v] Expected status-code=%v for path=%s but actual=%v", i, http.StatusOK, testCase.path, rec.Code)
+			continue
+		}
+		if !strings.Contains(rec.Body.String(), testCase.contains) {
+			t.Errorf("[i=%v] Expected body for path=%s to contain %q but actual=%q", i, testCase.path, testCase.contains, rec.Body.String())
+		}
+	}
+
+	// Optional endpoints are absent unless configured.
+	h = testHandler(t, Options{Insecure: true})
+	for _, path := range []string{"/debug/routes", "/metrics", "/healthz"} {
+		if rec := serve(h, "GET", path, "", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status-code=%v for unconfigured path=%s but actual=%v", http.StatusNotFound, path, rec.Code)
+		}
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	defer logging.ResetLevel("admin.test")
+	h := testHandler(t, Options{Insecure: true})
+
+	testCases := []struct {
+		method    string
+		target    string
+		status    int
+		effective string
+	}{
+		{method: "PUT", target: "/debug/log-levels?subsystem=admin.test&level=warn", status: http.StatusOK, effective: "warn"},
+		{method: "GET", target: "/debug/log-levels?subsystem=admin.test.child", status: http.StatusOK, effective: "warn"},
+		{method: "POST", target: "/debug/log-levels?subsystem=admin.test&level=loud", status: http.StatusBadRequest},
+		{method: "PUT", target: "/debug/log-levels?level=warn", status: http.StatusBadRequest},
+		{method: "DELETE", target: "/debug/log-levels?subsystem=admin.test", status: http.StatusOK, effective: "debug"},
+		{method: "PATCH", target: "/debug/log-levels", status: http.StatusMethodNotAllowed},
+	}
+	for i, testCase := range testCases {
+		rec := serve(h, testCase.method, testCase.target, "", "")
+		if rec.Code != testCase.status {
+			t.Errorf("[i=%v] Expected status-code=%v but actual=%v body=%s", i, testCase.status, rec.Code, rec.Body.String())
+			continue
+		}
+		if len(testCase.effective) == 0 {
+			continue
+		}
+		var result struct {
+			Levels    map[string]string `json:"levels"`
+			Effective string            `json:"effective"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("[i=%v] %s", i, err)
+		}
+		if result.Effective != testCase.effective {
+			t.Errorf("[i=%v] Expected effective level=%q but actual=%q", i, testCase.effective, result.Effective)
+		}
+	}
+	if level := logging.LevelOf("admin.test"); level != logging.DebugLevel {
+		t.Errorf("Expected level to be reset to %v but actual=%v", logging.DebugLevel, level)
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	ws, err := NewServer(Options{Addr: "127.0.0.1:0", Auth: &auth.AuthOptions{User: "admin", Password: "secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Stop()
+
+	req, _ := http.NewRequest("GET", ws.BaseUrl()+"/debug/build", nil)
+	req.SetBasicAuth("admin", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status-code=%v but actual=%v", http.StatusOK, resp.StatusCode)
+	}
+}
@@ -7,12 +7,14 @@ package auth
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 )
 
 type basicAuth struct {
@@ -47,8 +49,54 @@ func (b basicAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Call the next handler on success.
-	b.h.ServeHTTP(w, r)
+	// Call the next handler on success, recording who was authenticated.
+	creds, _ := ParseCredentials(r)
+	b.h.ServeHTTP(w, withAuthenticatedUser(r, string(creds[0])))
+}
+
+type contextKey int
+
+const userKey contextKey = iota
+
+// userSlot carries the authenticated user back out to middleware which
+// wrapped BasicAuth, since BasicAuth only sees the request after they've run.
+type userSlot struct {
+	user string
+	ok   bool
+	lock sync.Mutex
+}
+
+// TrackAuthenticatedUser returns a shallow copy of r with room for the user
+// to be recorded by BasicAuth, so that outer middleware (e.g. access logging)
+// can see it via AuthenticatedUser once the handler returns.
+func TrackAuthenticatedUser(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(userKey).(*userSlot); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), userKey, &userSlot{}))
+}
+
+func withAuthenticatedUser(r *http.Request, user string) *http.Request {
+	if slot, ok := r.Context().Value(userKey).(*userSlot); ok {
+		slot.lock.Lock()
+		slot.user, slot.ok = user, true
+		slot.lock.Unlock()
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), userKey, &userSlot{user: user, ok: true}))
+}
+
+// AuthenticatedUser returns the username verified by BasicAuth for the
+// request.  Unlike ParseCredentials it can't be spoofed, since it's only set
+// once the password has been checked.
+func AuthenticatedUser(r *http.Request) (string, bool) {
+	slot, ok := r.Context().Value(userKey).(*userSlot)
+	if !ok {
+		return "", false
+	}
+	slot.lock.Lock()
+	defer slot.lock.Unlock()
+	return slot.user, slot.ok
 }
 
 const basicScheme string = "Basic "
@@ -3,6 +3,7 @@ package auth
 import (
 	"encoding/base64"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -94,3 +95,28 @@ func TestBasicAuthAuthFunc(t *testing.T) {
 		}
 	}
 }
+
+func TestAuthenticatedUser(t *testing.T) {
+	var (
+		user string
+		ok   bool
+	)
+	handler := SimpleBasicAuth("test-user", "plain-text-password")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok = AuthenticatedUser(r)
+	}))
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	if _, found := AuthenticatedUser(r); found {
+		t.Fatal("Expected no authenticated user before BasicAuth has run")
+	}
+	r.SetBasicAuth("test-user", "wrong-password")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if ok {
+		t.Fatal("Expected handler not to run for incorrect credentials")
+	}
+	r.SetBasicAuth("test-user", "plain-text-password")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if !ok || user != "test-user" {
+		t.Errorf("Expected authenticated user=%q but actual=%q (ok=%v)", "test-user", user, ok)
+	}
+}
@@ -0,0 +1,185 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+var (
+	EmptyMiddlewareNameError = errors.New("middleware name must not be empty")
+	NilMiddlewareError       = errors.New("middleware must not be nil")
+	DuplicateMiddlewareError = errors.New("a middleware with that name is already in the chain")
+	MiddlewareNotFoundError  = errors.New("no middleware with that name in the chain")
+)
+
+// Chain is an ordered list of named middleware.  The first middleware is the
+// outermost, i.e. sees the request first, e.g.:
+//
+//	chain := web.NewChain()
+//	chain.Append("requestId", web.RequestIdMiddleware)
+//	chain.Append("recovery", web.RecoveryMiddleware(web.RecoveryOptions{}))
+//	chain.InsertBefore("recovery", "accessLog", web.AccessLogMiddleware(web.AccessLogOptions{}))
+//
+//	options := web.WebServerOptions{Handler: chain.Then(mux)}
+//	// or, for a route bundle:
+//	rmb := route.RouteMiddlewareBundle{Middlewares: chain.Middlewares(), RouteData: routes}
+//
+// Adding a name twice is an error.  Additionally, should the same named
+// middleware end up wrapping a request twice, e.g. via both a server-wide
+// chain and a route bundle, the inner application is skipped and a warning is
+// logged.
+//
+// Chains are not safe for concurrent modification; build them during setup.
+type Chain struct {
+	entries []*chainEntry
+}
+
+type chainEntry struct {
+	name       string
+	middleware MiddlewareFunc
+	warnOnce   sync.Once
+}
+
+// NewChain creates an empty Chain.
+func NewChain() *Chain {
+	return &Chain{}
+}
+
+// Append adds a middleware to the end (innermost position) of the chain.
+func (c *Chain) Append(name string, middleware MiddlewareFunc) error {
+	return c.insert(len(c.entries), name, middleware)
+}
+
+// Prepend adds a middleware to the start (outermost position) of the chain.
+func (c *Chain) Prepend(name string, middleware MiddlewareFunc) error {
+	return c.insert(0, name, middleware)
+}
+
+// InsertBefore adds a middleware immediately before (outside of) the named
+// one.
+func (c *Chain) InsertBefore(before string, name string, middleware MiddlewareFunc) error {
+	i := c.index(before)
+	if i == -1 {
+		return MiddlewareNotFoundError
+	}
+	return c.insert(i, name, middleware)
+}
+
+// InsertAfter adds a middleware immediately after (inside of) the named one.
+func (c *Chain) InsertAfter(after string, name string, middleware MiddlewareFunc) error {
+	i := c.index(after)
+	if i == -1 {
+		return MiddlewareNotFoundError
+	}
+	return c.insert(i+1, name, middleware)
+}
+
+// Remove takes the named middleware out of the chain.
+func (c *Chain) Remove(name string) error {
+	i := c.index(name)
+	if i == -1 {
+		return MiddlewareNotFoundError
+	}
+	c.entries = append(c.entries[:i:i], c.entries[i+1:]...)
+	return nil
+}
+
+// Has reports whether the named middleware is in the chain.
+func (c *Chain) Has(name string) bool {
+	return c.index(name) != -1
+}
+
+// Names lists the middleware in order, outermost first.
+func (c *Chain) Names() []string {
+	names := make([]string, len(c.entries))
+	for i, entry := range c.entries {
+		names[i] = entry.name
+	}
+	return names
+}
+
+// Then wraps h with every middleware in the chain.  A nil h means
+// http.DefaultServeMux.
+func (c *Chain) Then(h http.Handler) http.Handler {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		h = c.entries[i].wrap(h)
+	}
+	return h
+}
+
+// ThenFunc is Then for an http.HandlerFunc.
+func (c *Chain) ThenFunc(hf http.HandlerFunc) http.Handler {
+	return c.Then(hf)
+}
+
+// Middleware satisfies the MiddlewareFunc signature, so that one chain can be
+// nested within another.
+func (c *Chain) Middleware(next http.Handler) http.Handler {
+	return c.Then(next)
+}
+
+// Middlewares provides the chain in the form accepted by
+// route.RouteMiddlewareBundle.Middlewares.
+func (c *Chain) Middlewares() []func(http.Handler) http.Handler {
+	middlewares := make([]func(http.Handler) http.Handler, len(c.entries))
+	for i, entry := range c.entries {
+		middlewares[i] = entry.wrap
+	}
+	return middlewares
+}
+
+func (c *Chain) index(name string) int {
+	for i, entry := range c.entries {
+		if entry.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Chain) insert(i int, name string, middleware MiddlewareFunc) error {
+	if len(name) == 0 {
+		return EmptyMiddlewareNameError
+	}
+	if middleware == nil {
+		return NilMiddlewareError
+	}
+	if c.Has(name) {
+		return DuplicateMiddlewareError
+	}
+	entry := &chainEntry{
+		name:       name,
+		middleware: middleware,
+	}
+	c.entries = append(c.entries, nil)
+	copy(c.entries[i+1:], c.entries[i:])
+	c.entries[i] = entry
+	return nil
+}
+
+// appliedMiddleware records which named middleware have already wrapped a
+// request.
+type appliedMiddleware []string
+
+func (entry *chainEntry) wrap(next http.Handler) http.Handler {
+	wrapped := entry.middleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		applied, _ := req.Context().Value(appliedMiddlewareKey).(appliedMiddleware)
+		for _, name := range applied {
+			if name == entry.name {
+				entry.warnOnce.Do(func() {
+					logger.Warnf("web.Chain: middleware %q applied more than once to the same request, skipping the inner application", entry.name)
+				})
+				next.ServeHTTP(w, req)
+				return
+			}
+		}
+		applied = append(applied[:len(applied):len(applied)], entry.name)
+		wrapped.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), appliedMiddlewareKey, applied)))
+	})
+}
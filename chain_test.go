@@ -0,0 +1,82 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// tagMiddleware appends tag to the X-Trail response header.
+func tagMiddleware(tag string) MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Add("X-Trail", tag)
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func trail(h http.Handler) string {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptestRequest())
+	return strings.Join(rec.Header()["X-Trail"], ",")
+}
+
+func TestChain(t *testing.T) {
+	chain := NewChain()
+	steps := []struct {
+		fn            func() error
+		expectedErr   error
+		expectedNames string
+	}{
+		{func() error { return chain.Append("b", tagMiddleware("b")) }, nil, "b"},
+		{func() error { return chain.Append("d", tagMiddleware("d")) }, nil, "b,d"},
+		{func() error { return chain.Prepend("a", tagMiddleware("a")) }, nil, "a,b,d"},
+		{func() error { return chain.InsertBefore("d", "c", tagMiddleware("c")) }, nil, "a,b,c,d"},
+		{func() error { return chain.InsertAfter("d", "e", tagMiddleware("e")) }, nil, "a,b,c,d,e"},
+		{func() error { return chain.Append("c", tagMiddleware("c")) }, DuplicateMiddlewareError, "a,b,c,d,e"},
+		{func() error { return chain.InsertBefore("nope", "x", tagMiddleware("x")) }, MiddlewareNotFoundError, "a,b,c,d,e"},
+		{func() error { return chain.Append("", tagMiddleware("x")) }, EmptyMiddlewareNameError, "a,b,c,d,e"},
+		{func() error { return chain.Append("x", nil) }, NilMiddlewareError, "a,b,c,d,e"},
+		{func() error { return chain.Remove("e") }, nil, "a,b,c,d"},
+		{func() error { return chain.Remove("e") }, MiddlewareNotFoundError, "a,b,c,d"},
+	}
+	for i, step := range steps {
+		if expected, actual := step.expectedErr, step.fn(); actual != expected {
+			t.Errorf("[i=%v] Expected err=%v but actual=%v", i, expected, actual)
+		}
+		if expected, actual := step.expectedNames, strings.Join(chain.Names(), ","); actual != expected {
+			t.Errorf("[i=%v] Expected names=%q but actual=%q", i, expected, actual)
+		}
+	}
+	if expected, actual := "a,b,c,d", trail(chain.Then(http.NotFoundHandler())); actual != expected {
+		t.Errorf("Expected trail=%q but actual=%q", expected, actual)
+	}
+
+	// Route bundle form.
+	var h http.Handler = http.NotFoundHandler()
+	middlewares := chain.Middlewares()
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	if expected, actual := "a,b,c,d", trail(h); actual != expected {
+		t.Errorf("Expected Middlewares() trail=%q but actual=%q", expected, actual)
+	}
+}
+
+func TestChainDuplicateApplication(t *testing.T) {
+	outer := NewChain()
+	outer.Append("a", tagMiddleware("a"))
+	outer.Append("b", tagMiddleware("b"))
+	inner := NewChain()
+	inner.Append("b", tagMiddleware("b"))
+	inner.Append("c", tagMiddleware("c"))
+
+	if expected, actual := "a,b,c", trail(outer.Then(inner.Then(http.NotFoundHandler()))); actual != expected {
+		t.Errorf("Expected duplicate middleware to be skipped with trail=%q but actual=%q", expected, actual)
+	}
+	if expected, actual := "b,c,a", trail(inner.Then(outer.Then(http.NotFoundHandler()))); actual != expected {
+		t.Errorf("Expected duplicate middleware to be skipped with trail=%q but actual=%q", expected, actual)
+	}
+}
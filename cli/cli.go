@@ -10,7 +10,12 @@ import (
 	"os/signal"
 
 	"github.com/gigawattio/upstart"
+	"github.com/gigawattio/web"
+	"github.com/gigawattio/web/admin"
+	"github.com/gigawattio/web/auth"
 	"github.com/gigawattio/web/interfaces"
+	"github.com/gigawattio/web/systemd"
+	"github.com/gigawattio/web/upgrade"
 
 	cliv2 "gopkg.in/urfave/cli.v2"
 )
@@ -18,6 +23,7 @@ import (
 var (
 	DefaultBindAddr    = "127.0.0.1:8080"
 	DefaultServiceUser = os.Getenv("USER")
+	DefaultAdminUser   = "admin"
 )
 
 // Options provider for Cli struct.
@@ -30,7 +36,8 @@ type Options struct {
 	Stderr             io.Writer
 	WebServiceProvider interfaces.WebServiceProvider
 	Args               []string
-	ExitOnError        bool // Exit on non-nil error during invocation of `Main()`.
+	ExitOnError        bool          // Exit on non-nil error during invocation of `Main()`.
+	Admin              admin.Options // Admin server configuration, used when the --admin flag is set.
 }
 
 // Cli provides a command-line-interface in-a-box for web-services.
@@ -42,7 +49,11 @@ type Cli struct {
 	Uninstall          bool   // NB: Flag variable.
 	ServiceUser        string // NB: Flag variable.
 	BindAddr           string // NB: Flag variable.
-	ExitOnError        bool   // true triggers os.exit upon error from Main().
+	AdminAddr          string // NB: Flag variable.
+	AdminUser          string // NB: Flag variable.
+	AdminPassword      string // NB: Flag variable.
+	Admin              admin.Options
+	ExitOnError        bool // true triggers os.exit upon error from Main().
 	initialized        bool
 }
 
@@ -58,6 +69,7 @@ func New(options Options) (*Cli, error) {
 		},
 		WebServiceProvider: options.WebServiceProvider,
 		Args:               options.Args,
+		Admin:              options.Admin,
 		ExitOnError:        options.ExitOnError,
 	}
 	if err := cli.Init(); err != nil {
@@ -89,6 +101,9 @@ func (cli *Cli) Init() error {
 	if len(cli.BindAddr) == 0 {
 		cli.BindAddr = DefaultBindAddr
 	}
+	if len(cli.AdminUser) == 0 {
+		cli.AdminUser = DefaultAdminUser
+	}
 
 	// Auto-populate empty fields with os.* equivalents.
 	if cli.Args == nil {
@@ -132,6 +147,23 @@ func (cli *Cli) Init() error {
 				Value:       cli.BindAddr,
 				Destination: &cli.BindAddr,
 			},
+			&cliv2.StringFlag{
+				Name:        "admin",
+				Usage:       fmt.Sprintf("Enable the admin/debug server on this address, e.g. %s", admin.DefaultAddr),
+				Destination: &cli.AdminAddr,
+			},
+			&cliv2.StringFlag{
+				Name:        "admin-user",
+				Usage:       "Set the admin server basic auth user",
+				Value:       cli.AdminUser,
+				Destination: &cli.AdminUser,
+			},
+			&cliv2.StringFlag{
+				Name:        "admin-password",
+				Usage:       "Set the admin server basic auth password",
+				EnvVars:     []string{"ADMIN_PASSWORD"},
+				Destination: &cli.AdminPassword,
+			},
 		}...,
 	)
 	// Setup default action
@@ -190,12 +222,64 @@ func (cli *Cli) RunWeb(ctx *cliv2.Context) error {
 	}
 	fmt.Fprintf(cli.App.Writer, "Successfully started web service on addr=%v\n", webService.Addr())
 
+	adminServer, err := cli.StartAdmin()
+	if err != nil {
+		webService.Stop()
+		return err
+	}
+	if adminServer != nil {
+		fmt.Fprintf(cli.App.Writer, "Successfully started admin server on addr=%v\n", adminServer.Addr())
+		defer adminServer.Stop()
+	}
+
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
+	signal.Notify(sig, runSignals...)
+	defer signal.Stop(sig)
 
-	<-sig // Wait for ^C signal.
-	fmt.Fprintln(cli.App.ErrWriter, "\nInterrupt signal detected, shutting down..")
+	// When started by an upgrade, let the previous process know it can exit.
+	if err := upgrade.Ready(); err != nil {
+		fmt.Fprintf(cli.App.ErrWriter, "error: notifying previous process of readiness: %s\n", err)
+	}
 
+	// Report readiness and keep the watchdog fed when running under systemd.
+	cli.notify(systemd.Ready, systemd.Status("Serving on addr=%v", webService.Addr()))
+	stopKeepAlive := make(chan struct{})
+	if interval, err := systemd.WatchdogInterval(); err != nil {
+		fmt.Fprintf(cli.App.ErrWriter, "error: %s\n", err)
+	} else if interval > 0 {
+		go systemd.KeepAlive(interval, stopKeepAlive)
+	}
+
+	upgraded := false
+	for {
+		if s := <-sig; upgradeSignal == nil || s != upgradeSignal {
+			fmt.Fprintln(cli.App.ErrWriter, "\nInterrupt signal detected, shutting down..")
+			break
+		}
+		fmt.Fprintln(cli.App.ErrWriter, "\nUpgrade signal detected, starting new process..")
+		cli.notify(systemd.Reloading, systemd.Status("Upgrading"))
+		process, err := cli.Upgrade()
+		if err != nil {
+			fmt.Fprintf(cli.App.ErrWriter, "error: upgrade failed, continuing to serve: %s\n", err)
+			cli.notify(systemd.Ready, systemd.Status("Serving on addr=%v, upgrade failed: %s", webService.Addr(), err))
+			continue
+		}
+		fmt.Fprintf(cli.App.ErrWriter, "Upgraded to pid=%v, shutting down..\n", process.Pid)
+		// The new process takes over as the main process of the unit, which
+		// requires NotifyAccess=all for its own notifications to be accepted.
+		cli.notify(fmt.Sprintf("MAINPID=%v", process.Pid))
+		upgraded = true
+		break
+	}
+	close(stopKeepAlive)
+	// After a handoff the unit lives on in the new process, so STOPPING=1,
+	// which would have systemd stop the unit, mustn't be sent.
+	if !upgraded {
+		cli.notify(systemd.Stopping, systemd.Status("Shutting down"))
+	}
+
+	// Stop lets in-flight requests finish, which matters most after a
+	// handoff, when the new process is already accepting connections.
 	if err := webService.Stop(); err != nil {
 		return err
 	}
@@ -203,6 +287,50 @@ func (cli *Cli) RunWeb(ctx *cliv2.Context) error {
 	return nil
 }
 
+// Upgrade starts a new copy of the running executable with the same
+// arguments, handing over all WebServer listeners, and returns once it's
+// ready to serve.  See upgrade.Upgrade.
+func (cli *Cli) Upgrade() (*os.Process, error) {
+	return upgrade.Upgrade(upgrade.Options{
+		Args:   cli.Args,
+		Stdout: cli.App.Writer,
+		Stderr: cli.App.ErrWriter,
+	})
+}
+
+// notify sends states to systemd, when running under it.
+func (cli *Cli) notify(states ...string) {
+	if _, err := systemd.Notify(states...); err != nil {
+		fmt.Fprintf(cli.App.ErrWriter, "error: notifying systemd: %s\n", err)
+	}
+}
+
+// StartAdmin starts the admin server when the --admin flag is set, returning
+// nil otherwise.  Credentials from cli.Admin.Auth take precedence over the
+// --admin-user and --admin-password flags; one or the other is required
+// unless cli.Admin.Insecure is set.
+func (cli *Cli) StartAdmin() (*web.WebServer, error) {
+	if len(cli.AdminAddr) == 0 {
+		return nil, nil
+	}
+	options := cli.Admin
+	options.Addr = cli.AdminAddr
+	if options.Auth == nil && len(cli.AdminPassword) > 0 {
+		options.Auth = &auth.AuthOptions{
+			User:     cli.AdminUser,
+			Password: cli.AdminPassword,
+		}
+	}
+	adminServer, err := admin.NewServer(options)
+	if err != nil {
+		return nil, err
+	}
+	if err := adminServer.Start(); err != nil {
+		return nil, err
+	}
+	return adminServer, nil
+}
+
 func (cli *Cli) Main() error {
 	// Temporarily disable cliv2 os exiter and redirect ErrWriter to the one for
 	// this app.
@@ -3,14 +3,19 @@ package cli
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/gigawattio/errorlib"
 	"github.com/gigawattio/testlib"
+	"github.com/gigawattio/web/admin"
 	service "github.com/gigawattio/web/cli/example/service"
 	"github.com/gigawattio/web/interfaces"
 
@@ -230,3 +235,138 @@ func TestCliOutputOverrides(t *testing.T) {
 		t.Errorf("Expected c.App.ErrWriter == fakeStderr (*bytes.Buffer) but it was set to something else instead; actual value=%T/%p", c.App.ErrWriter, c.App.ErrWriter)
 	}
 }
+
+func TestCliAdminFlag(t *testing.T) {
+	options := Options{
+		AppName:            testlib.CurrentRunningTest(),
+		WebServiceProvider: simpleWebServiceProvider,
+		Args:               genTestCliArgs("--admin", "127.0.0.1:0", "--admin-password", "secret"),
+		Stdout:             &bytes.Buffer{},
+		Stderr:             &bytes.Buffer{},
+	}
+	cli, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli.App.Action = func(ctx *cliv2.Context) error {
+		adminServer, err := cli.StartAdmin()
+		if err != nil {
+			return err
+		}
+		if adminServer == nil {
+			t.Fatal("Expected an admin server when --admin is set")
+		}
+		defer adminServer.Stop()
+
+		resp, _, errs := gorequest.New().Get(adminServer.BaseUrl()+"/debug/runtime").SetBasicAuth(DefaultAdminUser, "secret").End()
+		if err := errorlib.Merge(errs); err != nil {
+			t.Error(err)
+		}
+		if expected := http.StatusOK; resp.StatusCode != expected {
+			t.Errorf("Expected response status-code=%v but actual=%v", expected, resp.StatusCode)
+		}
+		resp, _, errs = gorequest.New().Get(adminServer.BaseUrl() + "/debug/runtime").End()
+		if err := errorlib.Merge(errs); err != nil {
+			t.Error(err)
+		}
+		if expected := http.StatusUnauthorized; resp.StatusCode != expected {
+			t.Errorf("Expected unauthenticated response status-code=%v but actual=%v", expected, resp.StatusCode)
+		}
+		return nil
+	}
+	if err := cli.Main(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCliAdminFlagRequiresCredentials(t *testing.T) {
+	options := Options{
+		AppName:            testlib.CurrentRunningTest(),
+		WebServiceProvider: simpleWebServiceProvider,
+		Args:               genTestCliArgs("--admin", "127.0.0.1:0"),
+		Stdout:             &bytes.Buffer{},
+		Stderr:             &bytes.Buffer{},
+	}
+	cli, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli.App.Action = func(ctx *cliv2.Context) error {
+		_, err := cli.StartAdmin()
+		return err
+	}
+	if expected, actual := admin.MissingAuthError, cli.Main(); actual != expected {
+		t.Errorf("Expected error=%v but actual=%v", expected, actual)
+	}
+}
+
+func TestCliSystemdNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cli-notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	notifySocket, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "notify.sock"), Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notifySocket.Close()
+	os.Setenv("NOTIFY_SOCKET", notifySocket.LocalAddr().String())
+	os.Setenv("WATCHDOG_USEC", "20000")
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	defer os.Unsetenv("WATCHDOG_USEC")
+
+	options := Options{
+		AppName:            testlib.CurrentRunningTest(),
+		WebServiceProvider: simpleWebServiceProvider,
+		Args:               genTestCliArgs("--bind", "127.0.0.1:0"),
+		Stdout:             &bytes.Buffer{},
+		Stderr:             &bytes.Buffer{},
+	}
+	c, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan error, 1)
+	go func() { ch <- c.Main() }()
+
+	read := func() string {
+		buf := make([]byte, 4096)
+		notifySocket.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := notifySocket.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	if actual := read(); !strings.HasPrefix(actual, "READY=1\nSTATUS=Serving on addr=127.0.0.1:") {
+		t.Errorf("Expected READY=1 with status but actual=%q", actual)
+	}
+	if expected, actual := "WATCHDOG=1", read(); actual != expected {
+		t.Errorf("Expected datagram=%q but actual=%q", expected, actual)
+	}
+
+	process, _ := os.FindProcess(os.Getpid())
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		actual := read()
+		if actual == "WATCHDOG=1" {
+			continue // Sent before the signal was processed.
+		}
+		if expected := "STOPPING=1\nSTATUS=Shutting down"; actual != expected {
+			t.Errorf("Expected datagram=%q but actual=%q", expected, actual)
+		}
+		break
+	}
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for Main to return after SIGTERM")
+	}
+}
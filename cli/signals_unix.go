@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"os"
+	"syscall"
+)
+
+// runSignals are the signals RunWeb acts on.
+var runSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, upgradeSignal}
+
+// upgradeSignal triggers a zero-downtime upgrade, see Cli.Upgrade.
+var upgradeSignal os.Signal = syscall.SIGUSR2
@@ -0,0 +1,13 @@
+package cli
+
+import (
+	"os"
+	"syscall"
+)
+
+// runSignals are the signals RunWeb acts on.
+var runSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// upgradeSignal is nil since Windows has no SIGUSR2, so upgrades are
+// unavailable.
+var upgradeSignal os.Signal
@@ -0,0 +1,220 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIpResolver determines the real client IP address and scheme of
+// requests which arrive through reverse proxies / load balancers.
+//
+// Forwarding headers (Forwarded, X-Forwarded-For, X-Forwarded-Proto and
+// X-Real-IP) are only honored when the immediate peer is a trusted proxy, and
+// the forwarding chain is only followed back through further trusted proxies,
+// so clients can't spoof their address.
+type ClientIpResolver struct {
+	trusted []*net.IPNet
+}
+
+type clientInfo struct {
+	ip     string
+	scheme string
+}
+
+// NewClientIpResolver creates a ClientIpResolver trusting the specified
+// proxies, each of which may be a CIDR (e.g. "10.0.0.0/8") or a single IP.
+func NewClientIpResolver(trustedProxies []string) (*ClientIpResolver, error) {
+	trusted, err := parseCidrs(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy: %s", err)
+	}
+	resolver := &ClientIpResolver{
+		trusted: trusted,
+	}
+	return resolver, nil
+}
+
+// Middleware resolves the client IP and scheme and stores them in the request
+// context, where ClientIp and ClientScheme retrieve them.
+func (resolver *ClientIpResolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ip, scheme := resolver.Resolve(req)
+		info := &clientInfo{ip: ip, scheme: scheme}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), clientInfoKey, info)))
+	})
+}
+
+// Resolve determines the client IP and scheme of a request.
+func (resolver *ClientIpResolver) Resolve(req *http.Request) (ip string, scheme string) {
+	ip = remoteHost(req)
+	scheme = directScheme(req)
+	if !resolver.Trusted(ip) {
+		return
+	}
+
+	var hops []forwardedHop
+	if forwarded := req.Header["Forwarded"]; len(forwarded) > 0 {
+		hops = parseForwarded(forwarded)
+	} else if xff := req.Header["X-Forwarded-For"]; len(xff) > 0 {
+		for _, header := range xff {
+			for _, addr := range strings.Split(header, ",") {
+				hops = append(hops, forwardedHop{addr: strings.TrimSpace(addr)})
+			}
+		}
+		// Set by the nearest proxy, i.e. the last hop, whose entry is the
+		// last of a list.
+		if protos := strings.Split(req.Header.Get("X-Forwarded-Proto"), ","); len(protos[0]) > 0 {
+			hops[len(hops)-1].proto = protos[len(protos)-1]
+		}
+	} else if realIp := strings.TrimSpace(req.Header.Get("X-Real-IP")); net.ParseIP(realIp) != nil {
+		ip = realIp
+		if proto, ok := forwardedScheme(req.Header.Get("X-Forwarded-Proto")); ok {
+			scheme = proto
+		}
+		return
+	}
+
+	// Walk from the nearest hop back towards the client, stopping at the
+	// first address which isn't a trusted proxy.
+	for i := len(hops) - 1; i >= 0; i-- {
+		hopIp := hopHost(hops[i].addr)
+		if net.ParseIP(hopIp) == nil {
+			// Obfuscated or garbled; nothing further back can be believed.
+			break
+		}
+		ip = hopIp
+		if proto, ok := forwardedScheme(hops[i].proto); ok {
+			scheme = proto
+		}
+		if !resolver.Trusted(hopIp) {
+			break
+		}
+	}
+	return
+}
+
+// Trusted reports whether ip belongs to a trusted proxy.
+func (resolver *ClientIpResolver) Trusted(ip string) bool {
+	return cidrsContain(resolver.trusted, net.ParseIP(ip))
+}
+
+// ClientIp returns the client IP resolved by ClientIpResolver.Middleware, or
+// the host portion of req.RemoteAddr when the middleware isn't in use.
+func ClientIp(req *http.Request) string {
+	if info, ok := req.Context().Value(clientInfoKey).(*clientInfo); ok {
+		return info.ip
+	}
+	return remoteHost(req)
+}
+
+// ClientScheme returns the scheme ("http" or "https") the client used, as
+// resolved by ClientIpResolver.Middleware, or otherwise as seen directly.
+func ClientScheme(req *http.Request) string {
+	if info, ok := req.Context().Value(clientInfoKey).(*clientInfo); ok {
+		return info.scheme
+	}
+	return directScheme(req)
+}
+
+func remoteHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func directScheme(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// forwardedScheme normalizes a forwarded protocol, reporting false unless
+// it's "http" or "https".
+func forwardedScheme(proto string) (string, bool) {
+	proto = strings.ToLower(strings.TrimSpace(proto))
+	return proto, proto == "http" || proto == "https"
+}
+
+type forwardedHop struct {
+	addr  string
+	proto string
+}
+
+// parseForwarded extracts the for= and proto= parameters of each element of
+// RFC 7239 Forwarded headers, e.g.:
+//
+//	Forwarded: for=192.0.2.60;proto=https, for="[2001:db8::1]:4711"
+func parseForwarded(headers []string) []forwardedHop {
+	var hops []forwardedHop
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			var hop forwardedHop
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				value := strings.Trim(kv[1], `"`)
+				switch strings.ToLower(kv[0]) {
+				case "for":
+					hop.addr = value
+				case "proto":
+					hop.proto = value
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseCidrs parses a list of CIDRs and bare IPs, the latter being treated as
+// single-address networks.
+func parseCidrs(cidrs []string) ([]*net.IPNet, error) {
+	ipNets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
+
+func cidrsContain(ipNets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hopHost strips any port and IPv6 brackets from a forwarded address.
+func hopHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
@@ -0,0 +1,137 @@
+package web
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIpResolver(t *testing.T) {
+	resolver, err := NewClientIpResolver([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		remoteAddr     string
+		headers        map[string]string
+		tls            bool
+		expectedIp     string
+		expectedScheme string
+	}{
+		{
+			remoteAddr:     "203.0.113.7:1234",
+			expectedIp:     "203.0.113.7",
+			expectedScheme: "http",
+		},
+		{
+			// Untrusted peer can't spoof its address.
+			remoteAddr:     "203.0.113.7:1234",
+			headers:        map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Forwarded-Proto": "https"},
+			expectedIp:     "203.0.113.7",
+			expectedScheme: "http",
+		},
+		{
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.2, 10.1.1.1", "X-Forwarded-Proto": "https"},
+			expectedIp:     "198.51.100.2",
+			expectedScheme: "https",
+		},
+		{
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Forwarded-For": "198.51.100.2, 10.1.1.1", "X-Forwarded-Proto": "http, HTTPS"},
+			expectedIp:     "198.51.100.2",
+			expectedScheme: "https",
+		},
+		{
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Forwarded-For": "198.51.100.2", "X-Forwarded-Proto": "javascript"},
+			expectedIp:     "198.51.100.2",
+			expectedScheme: "http",
+		},
+		{
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Forwarded-For": "198.51.100.2, 10.1.1.1", "X-Forwarded-Proto": "https"},
+			expectedIp:     "198.51.100.2",
+			expectedScheme: "https",
+		},
+		{
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Forwarded-For": "198.51.100.2", "X-Forwarded-Proto": "https"},
+			expectedIp:     "198.51.100.2",
+			expectedScheme: "https",
+		},
+		{
+			remoteAddr:     "[2001:db8::1]:443",
+			headers:        map[string]string{"Forwarded": `for="[2001:db8::2]:4711";proto=https, for=10.2.2.2`},
+			expectedIp:     "2001:db8::2",
+			expectedScheme: "https",
+		},
+		{
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"Forwarded": "for=_hidden, for=10.2.2.2"},
+			expectedIp:     "10.2.2.2",
+			expectedScheme: "http",
+		},
+		{
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Real-IP": "198.51.100.9"},
+			tls:            true,
+			expectedIp:     "198.51.100.9",
+			expectedScheme: "https",
+		},
+		{
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"X-Real-IP": "198.51.100.9", "X-Forwarded-Proto": "gopher"},
+			tls:            true,
+			expectedIp:     "198.51.100.9",
+			expectedScheme: "https",
+		},
+		{
+			remoteAddr:     "10.0.0.1:1234",
+			headers:        map[string]string{"Forwarded": "for=198.51.100.2;proto=ftp"},
+			expectedIp:     "198.51.100.2",
+			expectedScheme: "http",
+		},
+	}
+	for i, testCase := range testCases {
+		var ip, scheme string
+		handler := resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ip, scheme = ClientIp(req), ClientScheme(req)
+		}))
+		req := httptestRequest()
+		req.RemoteAddr = testCase.remoteAddr
+		for k, v := range testCase.headers {
+			req.Header.Set(k, v)
+		}
+		if testCase.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if expected, actual := testCase.expectedIp, ip; actual != expected {
+			t.Errorf("[i=%v] Expected client ip=%q but actual=%q", i, expected, actual)
+		}
+		if expected, actual := testCase.expectedScheme, scheme; actual != expected {
+			t.Errorf("[i=%v] Expected client scheme=%q but actual=%q", i, expected, actual)
+		}
+	}
+}
+
+func TestClientIpWithoutMiddleware(t *testing.T) {
+	req := httptestRequest()
+	req.RemoteAddr = "192.0.2.1:5555"
+	if expected, actual := "192.0.2.1", ClientIp(req); actual != expected {
+		t.Errorf("Expected client ip=%q but actual=%q", expected, actual)
+	}
+	if expected, actual := "http", ClientScheme(req); actual != expected {
+		t.Errorf("Expected client scheme=%q but actual=%q", expected, actual)
+	}
+}
+
+func TestNewClientIpResolverInvalid(t *testing.T) {
+	for i, proxy := range []string{"nope", "10.0.0.0/33", ""} {
+		if _, err := NewClientIpResolver([]string{proxy}); err == nil {
+			t.Errorf("[i=%v] Expected error for trusted proxy=%q but actual=<nil>", i, proxy)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package web
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultCompressionMinSize is the smallest response body which
+// CompressionMiddleware will bother compressing.
+const DefaultCompressionMinSize = 1024
+
+// DefaultIncompressibleTypes lists Content-Type prefixes which are already
+// compressed, so compressing them again only wastes CPU.
+var DefaultIncompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/gzip",
+	"application/zip",
+	"application/x-gzip",
+	"application/x-bzip2",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/pdf",
+	"application/wasm",
+	"application/octet-stream",
+}
+
+// EncoderFunc wraps w with a compressing writer for a content-coding.  When
+// the returned writer has a `Flush() error` method it is used to support
+// http.Flusher.
+type EncoderFunc func(w io.Writer) io.WriteCloser
+
+// CompressionOptions configures CompressionMiddleware.
+type CompressionOptions struct {
+	MinSize             int                    // bodies smaller than this are sent as-is, DefaultCompressionMinSize if 0.
+	Level               int                    // gzip / deflate compression level, gzip.DefaultCompression if 0.
+	Encoders            map[string]EncoderFunc // additional or replacement encoders keyed by content-coding, e.g. "br".
+	Preference          []string               // tie-breaking order among equally weighted codings, "br", "gzip", "deflate" if empty.
+	IncompressibleTypes []string               // Content-Type prefixes to skip, DefaultIncompressibleTypes if nil.
+}
+
+// CompressionMiddleware generates a middleware function which compresses
+// response bodies according to the request's Accept-Encoding header.
+//
+// Output is buffered until MinSize bytes have been written, the handler
+// flushes, or the handler returns, and is then compressed unless it is too
+// small, already has a Content-Encoding, or has an incompressible
+// Content-Type.  Vary: Accept-Encoding is always set, since the response
+// depends on it either way.
+//
+// gzip and deflate are built in; a brotli encoder can be plugged in with:
+//
+//	Encoders: map[string]web.EncoderFunc{
+//		"br": func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+//	},
+func CompressionMiddleware(options CompressionOptions) MiddlewareFunc {
+	if options.MinSize <= 0 {
+		options.MinSize = DefaultCompressionMinSize
+	}
+	if options.Level == 0 {
+		options.Level = gzip.DefaultCompression
+	}
+	if options.IncompressibleTypes == nil {
+		options.IncompressibleTypes = DefaultIncompressibleTypes
+	}
+	if len(options.Preference) == 0 {
+		options.Preference = []string{"br", "gzip", "deflate"}
+	}
+	level := options.Level
+	encoders := map[string]EncoderFunc{
+		"gzip": func(w io.Writer) io.WriteCloser {
+			gz, err := gzip.NewWriterLevel(w, level)
+			if err != nil {
+				gz = gzip.NewWriter(w)
+			}
+			return gz
+		},
+		"deflate": func(w io.Writer) io.WriteCloser {
+			// The "deflate" content-coding is actually zlib-wrapped (RFC 7230 4.2.2).
+			zw, err := zlib.NewWriterLevel(w, level)
+			if err != nil {
+				zw = zlib.NewWriter(w)
+			}
+			return zw
+		},
+	}
+	for coding, encoder := range options.Encoders {
+		encoders[strings.ToLower(coding)] = encoder
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			addVary(w.Header(), "Accept-Encoding")
+			coding := negotiateEncoding(req.Header.Get("Accept-Encoding"), encoders, options.Preference)
+			if len(coding) == 0 || req.Method == "HEAD" {
+				next.ServeHTTP(w, req)
+				return
+			}
+			cw := &compressWriter{
+				ResponseWriter: w,
+				options:        &options,
+				coding:         coding,
+				encoder:        encoders[coding],
+			}
+			defer cw.close()
+			next.ServeHTTP(cw, req)
+		})
+	}
+}
+
+// compressWriter buffers the start of the body until it can decide whether
+// to compress, then either streams through an encoder or passes through.
+type compressWriter struct {
+	http.ResponseWriter
+	options *CompressionOptions
+	coding  string
+	encoder EncoderFunc
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = statusCode
+	// Informational and bodiless responses go straight out.
+	if statusCode < 200 || statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.options.MinSize {
+			return len(b), nil
+		}
+		if err := cw.commit(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush satisfies http.Flusher.  An undecided response is committed to
+// compression (size permitting aside) since flushing implies streaming.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.commit(true)
+	}
+	if f, ok := cw.enc.(interface {
+		Flush() error
+	}); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack satisfies http.Hijacker when the underlying writer does.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, HijackNotSupportedError
+	}
+	return hj.Hijack()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// commit decides whether to compress based on the headers and buffered body,
+// then sends the headers and any buffered data.
+func (cw *compressWriter) commit(largeEnough bool) error {
+	cw.decide(largeEnough && cw.compressible())
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (cw *compressWriter) decide(compress bool) {
+	if cw.decided {
+		return
+	}
+	cw.decided = true
+	if compress {
+		h := cw.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.coding)
+		if etag := h.Get("ETag"); len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+			// The compressed representation is no longer byte-identical.
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.enc = cw.encoder(cw.ResponseWriter)
+	}
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+}
+
+func (cw *compressWriter) compressible() bool {
+	h := cw.Header()
+	if len(h.Get("Content-Encoding")) > 0 || len(h.Get("Content-Range")) > 0 || cw.status == http.StatusPartialContent {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	if len(contentType) == 0 && len(cw.buf) > 0 {
+		// Match what net/http would sniff, and keep it from sniffing the
+		// compressed bytes instead.
+		contentType = http.DetectContentType(cw.buf)
+		h.Set("Content-Type", contentType)
+	}
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range cw.options.IncompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			// Nothing was written; let net/http send its implicit 200.
+			return
+		}
+		cw.commit(false)
+	}
+	if cw.enc != nil {
+		cw.enc.Close()
+	}
+}
+
+// negotiateEncoding picks the available content-coding with the highest
+// q-value in an Accept-Encoding header, or "" for identity.
+func negotiateEncoding(acceptEncoding string, encoders map[string]EncoderFunc, preference []string) string {
+	if len(acceptEncoding) == 0 {
+		return ""
+	}
+	var (
+		weights  = map[string]float64{}
+		wildcard = -1.0
+	)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if coding == "*" {
+			wildcard = q
+		} else {
+			weights[coding] = q
+		}
+	}
+
+	rank := map[string]int{}
+	for i, coding := range preference {
+		rank[coding] = i + 1
+	}
+	candidates := make([]string, 0, len(encoders))
+	for coding := range encoders {
+		q, ok := weights[coding]
+		if !ok {
+			q = wildcard
+		}
+		if q > 0 {
+			candidates = append(candidates, coding)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	weight := func(coding string) float64 {
+		if q, ok := weights[coding]; ok {
+			return q
+		}
+		return wildcard
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if weight(a) != weight(b) {
+			return weight(a) > weight(b)
+		}
+		ra, rb := rank[a], rank[b]
+		if ra == 0 {
+			ra = len(preference) + 1
+		}
+		if rb == 0 {
+			rb = len(preference) + 1
+		}
+		if ra != rb {
+			return ra < rb
+		}
+		return a < b
+	})
+	return candidates[0]
+}
+
+// addVary appends a value to the Vary header unless it's already present.
+func addVary(h http.Header, value string) {
+	for _, existing := range h["Vary"] {
+		for _, v := range strings.Split(existing, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), value) || strings.TrimSpace(v) == "*" {
+				return
+			}
+		}
+	}
+	h.Add("Vary", value)
+}
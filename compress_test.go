@@ -0,0 +1,151 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressionMiddleware(t *testing.T) {
+	large := strings.Repeat(`{"name":"gigawatt","value":42},`, 100)
+	testCases := []struct {
+		acceptEncoding   string
+		contentType      string
+		contentEncoding  string
+		body             string
+		expectedEncoding string
+	}{
+		{"gzip, deflate", "application/json", "", large, "gzip"},
+		{"deflate", "application/json", "", large, "deflate"},
+		{"gzip;q=0.5, deflate;q=0.9", "application/json", "", large, "deflate"},
+		{"*", "text/plain", "", large, "gzip"},
+		{"gzip;q=0, *;q=0.1", "text/plain", "", large, "deflate"},
+		{"", "application/json", "", large, ""},
+		{"identity", "application/json", "", large, ""},
+		{"gzip", "application/json", "", "small", ""},
+		{"gzip", "image/png", "", large, ""},
+		{"gzip", "application/json", "br", large, "br"},
+		{"gzip", "", "", "<!DOCTYPE html>" + large, "gzip"},
+	}
+	for i, testCase := range testCases {
+		handler := CompressionMiddleware(CompressionOptions{})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if len(testCase.contentEncoding) > 0 {
+				w.Header().Set("Content-Encoding", testCase.contentEncoding)
+			}
+			RespondWith(w, http.StatusOK, testCase.contentType, []byte(testCase.body))
+		}))
+		req := httptestRequest()
+		req.Header.Set("Accept-Encoding", testCase.acceptEncoding)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if expected, actual := testCase.expectedEncoding, rec.Header().Get("Content-Encoding"); actual != expected {
+			t.Errorf("[i=%v] Expected Content-Encoding=%q but actual=%q", i, expected, actual)
+		}
+		if expected, actual := "Accept-Encoding", rec.Header().Get("Vary"); actual != expected {
+			t.Errorf("[i=%v] Expected Vary=%q but actual=%q", i, expected, actual)
+		}
+		if expected, actual := http.StatusOK, rec.Code; actual != expected {
+			t.Errorf("[i=%v] Expected status-code=%v but actual=%v", i, expected, actual)
+		}
+		if body := decompress(t, testCase.expectedEncoding, rec.Body); body != testCase.body {
+			t.Errorf("[i=%v] Expected round-tripped body of length=%v but actual length=%v", i, len(testCase.body), len(body))
+		}
+	}
+}
+
+func TestCompressionMiddlewareStreaming(t *testing.T) {
+	chunks := make(chan string)
+	flushed := make(chan struct{})
+	handler := CompressionMiddleware(CompressionOptions{})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for chunk := range chunks {
+			io.WriteString(w, chunk)
+			w.(http.Flusher).Flush()
+			flushed <- struct{}{}
+		}
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	go func() {
+		chunks <- "data: one\n\n"
+	}()
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	<-flushed
+	if expected, actual := "gzip", resp.Header.Get("Content-Encoding"); actual != expected {
+		t.Fatalf("Expected Content-Encoding=%q but actual=%q", expected, actual)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := gz.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := "data: one\n\n", string(buf[:n]); actual != expected {
+		t.Errorf("Expected flushed chunk=%q before handler returned but actual=%q", expected, actual)
+	}
+	close(chunks)
+}
+
+func TestCompressionMiddlewarePluggableEncoder(t *testing.T) {
+	var used bool
+	handler := CompressionMiddleware(CompressionOptions{
+		MinSize: 1,
+		Encoders: map[string]EncoderFunc{
+			"x-test": func(w io.Writer) io.WriteCloser {
+				used = true
+				return gzip.NewWriter(w)
+			},
+		},
+		Preference: []string{"x-test", "gzip"},
+	})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		RespondWithText(w, http.StatusOK, "hello")
+	}))
+	req := httptestRequest()
+	req.Header.Set("Accept-Encoding", "gzip, x-test")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if expected, actual := "x-test", rec.Header().Get("Content-Encoding"); actual != expected || !used {
+		t.Errorf("Expected Content-Encoding=%q from plugged in encoder but actual=%q", expected, actual)
+	}
+}
+
+func decompress(t *testing.T, encoding string, r io.Reader) string {
+	var (
+		reader io.Reader
+		err    error
+	)
+	switch encoding {
+	case "gzip":
+		reader, err = gzip.NewReader(r)
+	case "deflate":
+		reader, err = zlib.NewReader(r)
+	default:
+		reader = r
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, reader); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(&buf)
+	return string(b)
+}
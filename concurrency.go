@@ -0,0 +1,227 @@
+package web
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultConcurrencyRetryAfter   = time.Second
+	DefaultConcurrencyQueueTimeout = time.Second
+	DefaultAdaptiveWindow          = time.Second
+	DefaultAdaptiveBackoff         = 0.9
+	DefaultAdaptiveTargetLatency   = 100 * time.Millisecond
+)
+
+// ConcurrencyOptions configures a ConcurrencyLimiter.
+type ConcurrencyOptions struct {
+	Limit        int              // Maximum number of in-flight requests.
+	QueueSize    int              // Maximum number of requests waiting for a slot, 0 rejects immediately.
+	QueueTimeout time.Duration    // Maximum time a request waits for a slot, defaults to DefaultConcurrencyQueueTimeout when queueing.
+	RetryAfter   time.Duration    // Sent with 503 responses, defaults to DefaultConcurrencyRetryAfter.
+	Adaptive     *AdaptiveOptions // Optional latency based adjustment of Limit.
+}
+
+// AdaptiveOptions enables AIMD (additive increase, multiplicative decrease)
+// adjustment of the concurrency limit: at the end of each window the limit
+// shrinks by Backoff when the average latency exceeded TargetLatency, and
+// otherwise grows by one if the limit was reached during the window.
+type AdaptiveOptions struct {
+	MinLimit      int
+	MaxLimit      int
+	TargetLatency time.Duration // Defaults to DefaultAdaptiveTargetLatency.
+	Window        time.Duration // Defaults to DefaultAdaptiveWindow.
+	Backoff       float64       // Multiplicative decrease factor, defaults to DefaultAdaptiveBackoff.
+}
+
+// ConcurrencyLimiter caps the number of requests handled at once, queueing a
+// bounded number of the excess and shedding the rest with 503.
+//
+// Use one limiter in WebServerOptions (or around the top-level handler) for a
+// global cap, and separate limiters in route bundles to cap classes of routes
+// independently.
+type ConcurrencyLimiter struct {
+	options  ConcurrencyOptions
+	limit    int
+	inFlight int
+	waiters  *list.List // Of *concurrencyWaiter, front is next in line.
+	lock     sync.Mutex
+
+	// Adaptive window stats.
+	windowStart time.Time
+	samples     int
+	latencySum  time.Duration
+	saturated   bool
+}
+
+type concurrencyWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// NewConcurrencyLimiter creates a ConcurrencyLimiter.
+func NewConcurrencyLimiter(options ConcurrencyOptions) *ConcurrencyLimiter {
+	if options.Limit <= 0 {
+		options.Limit = 1
+	}
+	if options.RetryAfter <= 0 {
+		options.RetryAfter = DefaultConcurrencyRetryAfter
+	}
+	if options.QueueSize > 0 && options.QueueTimeout <= 0 {
+		options.QueueTimeout = DefaultConcurrencyQueueTimeout
+	}
+	if options.Adaptive != nil {
+		adaptive := *options.Adaptive // Don't modify the caller's copy.
+		options.Adaptive = &adaptive
+		if adaptive.MinLimit <= 0 {
+			adaptive.MinLimit = 1
+		}
+		if adaptive.MaxLimit < options.Limit {
+			adaptive.MaxLimit = options.Limit
+		}
+		if adaptive.TargetLatency <= 0 {
+			adaptive.TargetLatency = DefaultAdaptiveTargetLatency
+		}
+		if adaptive.Window <= 0 {
+			adaptive.Window = DefaultAdaptiveWindow
+		}
+		if adaptive.Backoff <= 0 || adaptive.Backoff >= 1 {
+			adaptive.Backoff = DefaultAdaptiveBackoff
+		}
+	}
+	cl := &ConcurrencyLimiter{
+		options:     options,
+		limit:       options.Limit,
+		waiters:     list.New(),
+		windowStart: time.Now(),
+	}
+	return cl
+}
+
+// Middleware satisfies the MiddlewareFunc signature.
+func (cl *ConcurrencyLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !cl.acquire(req) {
+			w.Header().Set("Retry-After", strconv.Itoa(int((cl.options.RetryAfter+time.Second-1)/time.Second)))
+			RespondWithJson(w, http.StatusServiceUnavailable, JsonErrorFor(req, "server is over capacity"))
+			return
+		}
+		start := time.Now()
+		defer func() {
+			cl.release(time.Since(start))
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Limit returns the current concurrency limit.
+func (cl *ConcurrencyLimiter) Limit() int {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	return cl.limit
+}
+
+// InFlight returns the number of requests currently being handled.
+func (cl *ConcurrencyLimiter) InFlight() int {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	return cl.inFlight
+}
+
+// Queued returns the number of requests waiting for a slot.
+func (cl *ConcurrencyLimiter) Queued() int {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	return cl.waiters.Len()
+}
+
+func (cl *ConcurrencyLimiter) acquire(req *http.Request) bool {
+	cl.lock.Lock()
+	if cl.inFlight < cl.limit {
+		cl.inFlight++
+		if cl.inFlight == cl.limit {
+			cl.saturated = true
+		}
+		cl.lock.Unlock()
+		return true
+	}
+	cl.saturated = true
+	if cl.waiters.Len() >= cl.options.QueueSize {
+		cl.lock.Unlock()
+		return false
+	}
+	waiter := &concurrencyWaiter{ready: make(chan struct{})}
+	element := cl.waiters.PushBack(waiter)
+	cl.lock.Unlock()
+
+	timer := time.NewTimer(cl.options.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case <-waiter.ready:
+		return true
+	case <-timer.C:
+	case <-req.Context().Done():
+	}
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	if waiter.granted {
+		// Lost the race with release; the slot is ours, but hand it back.
+		cl.releaseLocked()
+		return false
+	}
+	cl.waiters.Remove(element)
+	return false
+}
+
+func (cl *ConcurrencyLimiter) release(latency time.Duration) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	if cl.options.Adaptive != nil {
+		cl.adapt(latency)
+	}
+	cl.releaseLocked()
+}
+
+// releaseLocked frees a slot and hands any available slots to waiters.
+func (cl *ConcurrencyLimiter) releaseLocked() {
+	cl.inFlight--
+	// The limit may also have grown, letting more than one waiter in.
+	for cl.inFlight < cl.limit && cl.waiters.Len() > 0 {
+		cl.inFlight++
+		cl.grantLocked()
+	}
+}
+
+func (cl *ConcurrencyLimiter) grantLocked() {
+	waiter := cl.waiters.Remove(cl.waiters.Front()).(*concurrencyWaiter)
+	waiter.granted = true
+	close(waiter.ready)
+}
+
+func (cl *ConcurrencyLimiter) adapt(latency time.Duration) {
+	adaptive := cl.options.Adaptive
+	cl.samples++
+	cl.latencySum += latency
+	now := time.Now()
+	if now.Sub(cl.windowStart) < adaptive.Window {
+		return
+	}
+	average := cl.latencySum / time.Duration(cl.samples)
+	switch {
+	case average > adaptive.TargetLatency:
+		cl.limit = int(float64(cl.limit) * adaptive.Backoff)
+		if cl.limit < adaptive.MinLimit {
+			cl.limit = adaptive.MinLimit
+		}
+	case cl.saturated && cl.limit < adaptive.MaxLimit:
+		cl.limit++
+	}
+	cl.windowStart = now
+	cl.samples = 0
+	cl.latencySum = 0
+	cl.saturated = cl.inFlight >= cl.limit
+}
@@ -0,0 +1,166 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	var (
+		cl = NewConcurrencyLimiter(ConcurrencyOptions{
+			Limit:        2,
+			QueueSize:    1,
+			QueueTimeout: 5 * time.Second,
+		})
+		unblock = make(chan struct{})
+		started = make(chan struct{}, 10)
+		handler = cl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			started <- struct{}{}
+			<-unblock
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		wg    sync.WaitGroup
+		codes = make(chan int, 10)
+	)
+	serveAsync := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/", nil)
+			handler.ServeHTTP(rec, req)
+			codes <- rec.Code
+		}()
+	}
+
+	// Fill both slots.
+	serveAsync()
+	serveAsync()
+	<-started
+	<-started
+	// Third request queues.
+	serveAsync()
+	for deadline := time.Now().Add(2 * time.Second); cl.Queued() != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for request to queue, queued=%v", cl.Queued())
+		}
+	}
+	// Fourth request is shed since the queue is full.
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(rec, req)
+	if expected, actual := http.StatusServiceUnavailable, rec.Code; actual != expected {
+		t.Errorf("Expected status-code=%v but actual=%v", expected, actual)
+	}
+	if expected, actual := "1", rec.Header().Get("Retry-After"); actual != expected {
+		t.Errorf("Expected Retry-After=%q but actual=%q", expected, actual)
+	}
+
+	close(unblock)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusNoContent {
+			t.Errorf("Expected admitted requests to get status-code=%v but actual=%v", http.StatusNoContent, code)
+		}
+	}
+	if actual := cl.InFlight(); actual != 0 {
+		t.Errorf("Expected in-flight=0 after completion but actual=%v", actual)
+	}
+}
+
+func TestConcurrencyLimiterQueueTimeout(t *testing.T) {
+	cl := NewConcurrencyLimiter(ConcurrencyOptions{
+		Limit:        1,
+		QueueSize:    1,
+		QueueTimeout: 10 * time.Millisecond,
+	})
+	unblock := make(chan struct{})
+	started := make(chan struct{})
+	handler := cl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-unblock
+	}))
+	go handler.ServeHTTP(httptest.NewRecorder(), httptestRequest())
+	<-started
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptestRequest())
+	close(unblock)
+	if expected, actual := http.StatusServiceUnavailable, rec.Code; actual != expected {
+		t.Errorf("Expected status-code=%v after queue timeout but actual=%v", expected, actual)
+	}
+	if actual := cl.Queued(); actual != 0 {
+		t.Errorf("Expected timed out waiter to leave the queue but queued=%v", actual)
+	}
+}
+
+func TestConcurrencyLimiterDefaultQueueTimeout(t *testing.T) {
+	cl := NewConcurrencyLimiter(ConcurrencyOptions{Limit: 1, QueueSize: 1})
+	if expected, actual := DefaultConcurrencyQueueTimeout, cl.options.QueueTimeout; actual != expected {
+		t.Errorf("Expected QueueTimeout=%s but actual=%s", expected, actual)
+	}
+	unblock := make(chan struct{})
+	started := make(chan struct{})
+	handler := cl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-started:
+		default:
+			close(started)
+			<-unblock
+		}
+	}))
+	go handler.ServeHTTP(httptest.NewRecorder(), httptestRequest())
+	<-started
+	time.AfterFunc(10*time.Millisecond, func() { close(unblock) })
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptestRequest())
+	if expected, actual := http.StatusOK, rec.Code; actual != expected {
+		t.Errorf("Expected queued request to be served with status-code=%v but actual=%v", expected, actual)
+	}
+}
+
+func TestConcurrencyLimiterAdaptive(t *testing.T) {
+	cl := NewConcurrencyLimiter(ConcurrencyOptions{
+		Limit: 10,
+		Adaptive: &AdaptiveOptions{
+			MinLimit:      2,
+			MaxLimit:      20,
+			TargetLatency: time.Millisecond,
+			Window:        time.Nanosecond,
+			Backoff:       0.5,
+		},
+	})
+	slow := cl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+	}))
+	for _, expected := range []int{5, 2, 2} {
+		slow.ServeHTTP(httptest.NewRecorder(), httptestRequest())
+		if actual := cl.Limit(); actual != expected {
+			t.Fatalf("Expected limit=%v after slow request but actual=%v", expected, actual)
+		}
+	}
+}
+
+func TestConcurrencyLimiterAdaptiveDefaultTarget(t *testing.T) {
+	cl := NewConcurrencyLimiter(ConcurrencyOptions{
+		Limit:    10,
+		Adaptive: &AdaptiveOptions{Window: time.Nanosecond},
+	})
+	if expected, actual := DefaultAdaptiveTargetLatency, cl.options.Adaptive.TargetLatency; actual != expected {
+		t.Errorf("Expected TargetLatency=%s but actual=%s", expected, actual)
+	}
+	// A fast request must not count as slow when TargetLatency is unset.
+	fast := cl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	fast.ServeHTTP(httptest.NewRecorder(), httptestRequest())
+	if expected, actual := 10, cl.Limit(); actual != expected {
+		t.Errorf("Expected limit=%v after fast request but actual=%v", expected, actual)
+	}
+}
+
+func httptestRequest() *http.Request {
+	req, _ := http.NewRequest("GET", "/", nil)
+	return req
+}
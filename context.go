@@ -0,0 +1,14 @@
+package web
+
+// contextKey namespaces the request context values set by this package.
+type contextKey int
+
+const (
+	requestIdKey contextKey = iota
+	clientInfoKey
+	cspNonceKey
+	appliedMiddlewareKey
+	routePatternKey
+	errorRecordersKey
+	loggerKey
+)
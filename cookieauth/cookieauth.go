@@ -7,10 +7,12 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/gigawattio/web/logging"
 	"github.com/gorilla/securecookie"
-	log "github.com/sirupsen/logrus"
 )
 
+var logger = logging.For("cookieauth")
+
 // CookieAuth provides secure pure cookie-based authentication capabilities to
 // sign and set an encrypted userId in a cookie along with the timestamp of
 // when the cookie was signed/created.
@@ -59,7 +61,7 @@ func (cookieAuth *CookieAuth) Read(req *http.Request) (userId int64, err error)
 	userIdString, ok := value["userId"]
 	if !ok {
 		// No userId found in cookie value, return userId=0.
-		log.WithField("action", "cookieauth.read").Infof("no userId found in value=%v", value)
+		logger.With("action", "cookieauth.read").Infof("no userId found in value=%v", value)
 		return
 	}
 	if userId, err = strconv.ParseInt(userIdString, 10, 64); err != nil {
@@ -70,19 +72,19 @@ func (cookieAuth *CookieAuth) Read(req *http.Request) (userId int64, err error)
 	createdAtString, ok := value["createdAt"]
 	if !ok {
 		// No createdAt found in cookie value, return userId=0.
-		log.WithField("action", "cookieauth.read").Infof("no createdAt found in value=%v", value)
+		logger.With("action", "cookieauth.read").Infof("no createdAt found in value=%v", value)
 		return
 	}
 	createdAt, err := time.Parse(createdAtLayout, createdAtString)
-	log.WithField("action", "cookieauth.read").Debugf("createdAt=%v since=%v", createdAt, time.Since(createdAt))
+	logger.With("action", "cookieauth.read").Debugf("createdAt=%v since=%v", createdAt, time.Since(createdAt))
 	if time.Since(createdAt) > cookieAuth.expireAfter {
 		// Expired.
-		log.WithField("action", "cookieauth.read").Infof("discovered expired auth cookie createdAt=%s which more than %s ago", createdAt, cookieAuth.expireAfter)
+		logger.With("action", "cookieauth.read").Infof("discovered expired auth cookie createdAt=%s which more than %s ago", createdAt, cookieAuth.expireAfter)
 		userId = 0 // Zero out userId.
 		err = Expired
 		return
 	}
-	log.WithField("action", "cookieauth.read").Infof("found userId=%v", value)
+	logger.With("action", "cookieauth.read").Infof("found userId=%v", value)
 	return
 }
 
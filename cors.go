@@ -0,0 +1,213 @@
+package web
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCorsMethods are the methods allowed when CorsOptions.AllowedMethods
+// is empty.
+var DefaultCorsMethods = []string{"GET", "HEAD", "POST"}
+
+// DefaultCorsHeaders are the request headers allowed when
+// CorsOptions.AllowedHeaders is empty.
+var DefaultCorsHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type", RequestIdHeader}
+
+// CorsOptions configures CorsMiddleware.
+//
+// AllowedOrigins entries may be an exact origin ("https://app.example.com"),
+// a wildcard subdomain ("https://*.example.com") or "*" to allow any origin.
+type CorsOptions struct {
+	AllowedOrigins        []string
+	AllowedOriginPatterns []*regexp.Regexp // Matched against the full Origin header value; implicitly anchored at both ends.
+	AllowedMethods        []string         // Defaults to DefaultCorsMethods.
+	AllowedHeaders        []string         // Defaults to DefaultCorsHeaders, "*" reflects whatever is requested.
+	ExposedHeaders        []string
+	AllowCredentials      bool
+	MaxAge                time.Duration // How long preflight results may be cached, 0 omits the header.
+}
+
+type cors struct {
+	options      CorsOptions
+	anyOrigin    bool
+	origins      map[string]struct{}
+	schemes      []string // Scheme prefixes of wildcard origins, e.g. "https://".
+	wildcards    []string // Host suffixes of wildcard origins, e.g. ".example.com".
+	patterns     []*regexp.Regexp
+	methods      map[string]struct{}
+	headers      map[string]struct{}
+	anyHeader    bool
+	allowMethods string
+	allowHeaders string
+}
+
+// CorsMiddleware generates a middleware function which applies CORS headers
+// to cross-origin requests and answers preflight requests itself, so paths
+// don't need an OPTIONS route registered.
+//
+// When used in a route.RouteMiddlewareBundle the middleware runs ahead of
+// route matching, so preflights are answered for every path in the bundle.
+func CorsMiddleware(options CorsOptions) MiddlewareFunc {
+	if len(options.AllowedMethods) == 0 {
+		options.AllowedMethods = DefaultCorsMethods
+	}
+	if len(options.AllowedHeaders) == 0 {
+		options.AllowedHeaders = DefaultCorsHeaders
+	}
+	c := &cors{
+		options: options,
+		origins: map[string]struct{}{},
+		methods: map[string]struct{}{},
+		headers: map[string]struct{}{},
+	}
+	for _, origin := range options.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			c.anyOrigin = true
+		case strings.Contains(origin, "://*."):
+			i := strings.Index(origin, "://*.")
+			c.schemes = append(c.schemes, origin[:i+3])
+			c.wildcards = append(c.wildcards, origin[i+4:])
+		default:
+			c.origins[origin] = struct{}{}
+		}
+	}
+	for _, pattern := range options.AllowedOriginPatterns {
+		// Anchor so that e.g. `https://.*\.example\.com` can't be satisfied by
+		// "https://evil.example.com.attacker.net".
+		c.patterns = append(c.patterns, regexp.MustCompile(`^(?:`+pattern.String()+`)$`))
+	}
+	methods := make([]string, 0, len(options.AllowedMethods))
+	for _, method := range options.AllowedMethods {
+		method = strings.ToUpper(method)
+		methods = append(methods, method)
+		c.methods[method] = struct{}{}
+	}
+	for _, header := range options.AllowedHeaders {
+		if header == "*" {
+			c.anyHeader = true
+			continue
+		}
+		c.headers[http.CanonicalHeaderKey(header)] = struct{}{}
+	}
+	c.allowMethods = strings.Join(methods, ", ")
+	c.allowHeaders = strings.Join(options.AllowedHeaders, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// Unless every origin gets "*", the response depends on Origin,
+			// including its absence, so caches must key on it.
+			if !c.anyOrigin || options.AllowCredentials {
+				w.Header().Add("Vary", "Origin")
+			}
+			origin := req.Header.Get("Origin")
+			if len(origin) == 0 {
+				next.ServeHTTP(w, req)
+				return
+			}
+			if req.Method == "OPTIONS" && len(req.Header.Get("Access-Control-Request-Method")) > 0 {
+				c.preflight(w, req, origin)
+				return
+			}
+			if c.originAllowed(origin) {
+				c.setOriginHeaders(w, origin)
+				if len(options.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(options.ExposedHeaders, ", "))
+				}
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func (c *cors) preflight(w http.ResponseWriter, req *http.Request, origin string) {
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+	method := strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+	requested := parseHeaderList(req.Header.Get("Access-Control-Request-Headers"))
+	if !c.originAllowed(origin) || !c.methodAllowed(method) || !c.headersAllowed(requested) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	c.setOriginHeaders(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", c.allowMethods)
+	if c.anyHeader {
+		if len(requested) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		}
+	} else {
+		w.Header().Set("Access-Control-Allow-Headers", c.allowHeaders)
+	}
+	if c.options.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.options.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *cors) setOriginHeaders(w http.ResponseWriter, origin string) {
+	if c.anyOrigin && !c.options.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		// Credentialed requests must echo the specific origin.
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.options.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *cors) originAllowed(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if _, ok := c.origins[lower]; ok {
+		return true
+	}
+	for i, suffix := range c.wildcards {
+		if strings.HasPrefix(lower, c.schemes[i]) && strings.HasSuffix(lower, suffix) && len(lower) > len(c.schemes[i])+len(suffix) {
+			return true
+		}
+	}
+	for _, pattern := range c.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cors) methodAllowed(method string) bool {
+	// Simple methods are always permitted by the CORS protocol.
+	if method == "GET" || method == "HEAD" || method == "POST" {
+		return true
+	}
+	_, ok := c.methods[method]
+	return ok
+}
+
+func (c *cors) headersAllowed(requested []string) bool {
+	if c.anyHeader {
+		return true
+	}
+	for _, header := range requested {
+		if _, ok := c.headers[http.CanonicalHeaderKey(header)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func parseHeaderList(s string) []string {
+	var headers []string
+	for _, header := range strings.Split(s, ",") {
+		if header = strings.TrimSpace(header); len(header) > 0 {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
@@ -0,0 +1,104 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestCorsMiddleware(t *testing.T) {
+	handler := CorsMiddleware(CorsOptions{
+		AllowedOrigins:        []string{"https://app.example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`^http://localhost:\d+$`), regexp.MustCompile(`https://.*\.example\.net`)},
+		AllowedMethods:        []string{"get", "put", "delete"},
+		AllowedHeaders:        []string{"Content-Type", "X-Custom"},
+		ExposedHeaders:        []string{"X-Total-Count"},
+		AllowCredentials:      true,
+		MaxAge:                10 * time.Minute,
+	})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		RespondWithText(w, http.StatusOK, "routed")
+	}))
+
+	testCases := []struct {
+		method        string
+		origin        string
+		requestMethod string
+		requestHeader string
+		status        int
+		allowOrigin   string
+		body          string
+	}{
+		{"GET", "", "", "", http.StatusOK, "", "routed"},
+		{"GET", "https://app.example.com", "", "", http.StatusOK, "https://app.example.com", "routed"},
+		{"GET", "https://evil.example.com", "", "", http.StatusOK, "", "routed"},
+		{"GET", "https://a.b.example.org", "", "", http.StatusOK, "https://a.b.example.org", "routed"},
+		{"GET", "https://example.org", "", "", http.StatusOK, "", "routed"},
+		{"GET", "http://localhost:3000", "", "", http.StatusOK, "http://localhost:3000", "routed"},
+		{"GET", "https://api.example.net", "", "", http.StatusOK, "https://api.example.net", "routed"},
+		{"GET", "https://api.example.net.attacker.io", "", "", http.StatusOK, "", "routed"},
+		{"GET", "http://evil.io/https://api.example.net", "", "", http.StatusOK, "", "routed"},
+		{"OPTIONS", "https://app.example.com", "PUT", "x-custom", http.StatusNoContent, "https://app.example.com", ""},
+		{"OPTIONS", "https://app.example.com", "PATCH", "", http.StatusForbidden, "", ""},
+		{"OPTIONS", "https://app.example.com", "PUT", "X-Other", http.StatusForbidden, "", ""},
+		{"OPTIONS", "https://evil.example.com", "PUT", "", http.StatusForbidden, "", ""},
+		{"OPTIONS", "", "", "", http.StatusOK, "", "routed"},
+	}
+	for i, testCase := range testCases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(testCase.method, "/no/options/route", nil)
+		if len(testCase.origin) > 0 {
+			req.Header.Set("Origin", testCase.origin)
+		}
+		if len(testCase.requestMethod) > 0 {
+			req.Header.Set("Access-Control-Request-Method", testCase.requestMethod)
+		}
+		if len(testCase.requestHeader) > 0 {
+			req.Header.Set("Access-Control-Request-Headers", testCase.requestHeader)
+		}
+		handler.ServeHTTP(rec, req)
+		if rec.Code != testCase.status {
+			t.Errorf("[i=%v] Expected status-code=%v but actual=%v", i, testCase.status, rec.Code)
+		}
+		if actual := rec.Header().Get("Access-Control-Allow-Origin"); actual != testCase.allowOrigin {
+			t.Errorf("[i=%v] Expected Access-Control-Allow-Origin=%q but actual=%q", i, testCase.allowOrigin, actual)
+		}
+		if actual := rec.Body.String(); actual != testCase.body {
+			t.Errorf("[i=%v] Expected body=%q but actual=%q", i, testCase.body, actual)
+		}
+		if expected, actual := "Origin", rec.Header().Get("Vary"); actual != expected {
+			t.Errorf("[i=%v] Expected Vary=%q but actual=%q", i, expected, actual)
+		}
+		if len(testCase.allowOrigin) > 0 && rec.Header().Get("Access-Control-Allow-Credentials") != "true" {
+			t.Errorf("[i=%v] Expected Access-Control-Allow-Credentials=true", i)
+		}
+		if testCase.status == http.StatusNoContent {
+			if expected, actual := "GET, PUT, DELETE", rec.Header().Get("Access-Control-Allow-Methods"); actual != expected {
+				t.Errorf("[i=%v] Expected Access-Control-Allow-Methods=%q but actual=%q", i, expected, actual)
+			}
+			if expected, actual := "600", rec.Header().Get("Access-Control-Max-Age"); actual != expected {
+				t.Errorf("[i=%v] Expected Access-Control-Max-Age=%q but actual=%q", i, expected, actual)
+			}
+		}
+		if testCase.method == "GET" && len(testCase.allowOrigin) > 0 {
+			if expected, actual := "X-Total-Count", rec.Header().Get("Access-Control-Expose-Headers"); actual != expected {
+				t.Errorf("[i=%v] Expected Access-Control-Expose-Headers=%q but actual=%q", i, expected, actual)
+			}
+		}
+	}
+}
+
+func TestCorsMiddlewareAnyOrigin(t *testing.T) {
+	handler := CorsMiddleware(CorsOptions{AllowedOrigins: []string{"*"}})(http.NotFoundHandler())
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://anywhere.test")
+	handler.ServeHTTP(rec, req)
+	if expected, actual := "*", rec.Header().Get("Access-Control-Allow-Origin"); actual != expected {
+		t.Errorf("Expected Access-Control-Allow-Origin=%q but actual=%q", expected, actual)
+	}
+	if actual := rec.Header().Get("Vary"); len(actual) > 0 {
+		t.Errorf("Expected no Vary header when every origin gets \"*\" but actual=%q", actual)
+	}
+}
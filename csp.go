@@ -0,0 +1,225 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// CspNonceSource is a placeholder source which is replaced with the
+// per-request 'nonce-...' value when the policy is rendered.
+const CspNonceSource = "'nonce'"
+
+// MaxCspReportBytes caps the size of violation reports accepted by
+// CspReportHandler.
+const MaxCspReportBytes = 64 * 1024
+
+// ContentSecurityPolicy builds a Content-Security-Policy header value, e.g.:
+//
+//	csp := web.NewContentSecurityPolicy().
+//		Add("default-src", "'self'").
+//		Add("script-src", "'self'", web.CspNonceSource).
+//		Add("report-uri", "/csp-report")
+type ContentSecurityPolicy struct {
+	directives []cspDirective
+}
+
+type cspDirective struct {
+	name    string
+	sources []string
+}
+
+// NewContentSecurityPolicy creates an empty policy.
+func NewContentSecurityPolicy() *ContentSecurityPolicy {
+	return &ContentSecurityPolicy{}
+}
+
+// Add appends sources to a directive, creating the directive if necessary.
+// Directives render in the order they were first added.
+func (csp *ContentSecurityPolicy) Add(directive string, sources ...string) *ContentSecurityPolicy {
+	directive = strings.ToLower(directive)
+	for i := range csp.directives {
+		if csp.directives[i].name == directive {
+			csp.directives[i].sources = append(csp.directives[i].sources, sources...)
+			return csp
+		}
+	}
+	csp.directives = append(csp.directives, cspDirective{name: directive, sources: append([]string(nil), sources...)})
+	return csp
+}
+
+// Has reports whether the policy contains directive.
+func (csp *ContentSecurityPolicy) Has(directive string) bool {
+	directive = strings.ToLower(directive)
+	for _, d := range csp.directives {
+		if d.name == directive {
+			return true
+		}
+	}
+	return false
+}
+
+// UsesNonce reports whether any directive contains CspNonceSource.
+func (csp *ContentSecurityPolicy) UsesNonce() bool {
+	for _, d := range csp.directives {
+		for _, source := range d.sources {
+			if source == CspNonceSource {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Render produces the header value, substituting nonce for CspNonceSource.
+func (csp *ContentSecurityPolicy) Render(nonce string) string {
+	parts := make([]string, 0, len(csp.directives))
+	for _, d := range csp.directives {
+		tokens := []string{d.name}
+		for _, source := range d.sources {
+			if source == CspNonceSource {
+				source = "'nonce-" + nonce + "'"
+			}
+			tokens = append(tokens, source)
+		}
+		parts = append(parts, strings.Join(tokens, " "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// String renders the policy without a nonce.
+func (csp *ContentSecurityPolicy) String() string {
+	return csp.Render("")
+}
+
+// cspRandReader is the nonce entropy source, a variable so tests can make it
+// fail.
+var cspRandReader io.Reader = rand.Reader
+
+// NewCspNonce generates a random base64 nonce.  An error is returned rather
+// than a predictable nonce when random bytes aren't available.
+func NewCspNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(cspRandReader, b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// CspNonce returns the nonce generated for the request by
+// SecurityHeadersMiddleware, for use in templates, e.g.
+// <script nonce="{{.Nonce}}">.  It is empty when the policy doesn't use
+// CspNonceSource.
+func CspNonce(req *http.Request) string {
+	nonce, _ := req.Context().Value(cspNonceKey).(string)
+	return nonce
+}
+
+func withCspNonce(req *http.Request, nonce string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), cspNonceKey, nonce))
+}
+
+// CspReport is a CSP violation report.
+type CspReport struct {
+	DocumentUri        string `json:"document-uri"`
+	Referrer           string `json:"referrer"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	OriginalPolicy     string `json:"original-policy"`
+	Disposition        string `json:"disposition"`
+	BlockedUri         string `json:"blocked-uri"`
+	StatusCode         int    `json:"status-code"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+	ColumnNumber       int    `json:"column-number"`
+}
+
+// CspReportHandler collects violation reports sent to a report-uri, in either
+// the legacy application/csp-report format or the Reporting API
+// application/reports+json format, and passes each to fn.  A nil fn logs the
+// reports.
+func CspReportHandler(fn func(req *http.Request, report CspReport)) http.HandlerFunc {
+	if fn == nil {
+		fn = func(req *http.Request, report CspReport) {
+			RequestLogger(req).Warnf("web.CspReportHandler: violated-directive=%q blocked-uri=%q document-uri=%q", report.ViolatedDirective, report.BlockedUri, report.DocumentUri)
+		}
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			RespondWithJson(w, http.StatusMethodNotAllowed, JsonErrorFor(req, http.StatusText(http.StatusMethodNotAllowed)))
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, MaxCspReportBytes))
+		if err != nil {
+			RespondWithJson(w, http.StatusBadRequest, JsonErrorFor(req, err))
+			return
+		}
+		reports, err := decodeCspReports(body)
+		if err != nil {
+			RespondWithJson(w, http.StatusBadRequest, JsonErrorFor(req, err))
+			return
+		}
+		for _, report := range reports {
+			fn(req, report)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func decodeCspReports(body []byte) ([]CspReport, error) {
+	body = []byte(strings.TrimSpace(string(body)))
+	if len(body) > 0 && body[0] == '[' {
+		// Reporting API: [{"type":"csp-violation","body":{...}}, ...]
+		var entries []struct {
+			Type string `json:"type"`
+			Body struct {
+				DocumentUrl        string `json:"documentURL"`
+				Referrer           string `json:"referrer"`
+				EffectiveDirective string `json:"effectiveDirective"`
+				OriginalPolicy     string `json:"originalPolicy"`
+				Disposition        string `json:"disposition"`
+				BlockedUrl         string `json:"blockedURL"`
+				StatusCode         int    `json:"statusCode"`
+				SourceFile         string `json:"sourceFile"`
+				LineNumber         int    `json:"lineNumber"`
+				ColumnNumber       int    `json:"columnNumber"`
+			} `json:"body"`
+		}
+		if err := json.Unmarshal(body, &entries); err != nil {
+			return nil, err
+		}
+		reports := make([]CspReport, 0, len(entries))
+		for _, entry := range entries {
+			if entry.Type != "csp-violation" {
+				continue
+			}
+			reports = append(reports, CspReport{
+				DocumentUri:        entry.Body.DocumentUrl,
+				Referrer:           entry.Body.Referrer,
+				ViolatedDirective:  entry.Body.EffectiveDirective,
+				EffectiveDirective: entry.Body.EffectiveDirective,
+				OriginalPolicy:     entry.Body.OriginalPolicy,
+				Disposition:        entry.Body.Disposition,
+				BlockedUri:         entry.Body.BlockedUrl,
+				StatusCode:         entry.Body.StatusCode,
+				SourceFile:         entry.Body.SourceFile,
+				LineNumber:         entry.Body.LineNumber,
+				ColumnNumber:       entry.Body.ColumnNumber,
+			})
+		}
+		return reports, nil
+	}
+	var legacy struct {
+		Report CspReport `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return nil, err
+	}
+	return []CspReport{legacy.Report}, nil
+}
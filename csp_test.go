@@ -0,0 +1,68 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContentSecurityPolicy(t *testing.T) {
+	csp := NewContentSecurityPolicy().
+		Add("default-src", "'self'").
+		Add("SCRIPT-SRC", "'self'").
+		Add("script-src", CspNonceSource, "https://cdn.example.com").
+		Add("upgrade-insecure-requests")
+	if !csp.UsesNonce() {
+		t.Errorf("Expected UsesNonce=true")
+	}
+	if expected, actual := "default-src 'self'; script-src 'self' 'nonce-abc' https://cdn.example.com; upgrade-insecure-requests", csp.Render("abc"); actual != expected {
+		t.Errorf("Expected policy=%q but actual=%q", expected, actual)
+	}
+}
+
+func TestCspReportHandler(t *testing.T) {
+	testCases := []struct {
+		method              string
+		body                string
+		expectedStatusCode  int
+		expectedBlockedUris []string
+	}{
+		{
+			method:              "POST",
+			body:                `{"csp-report":{"document-uri":"https://example.com/","violated-directive":"script-src","blocked-uri":"https://evil.example.com/x.js","line-number":7}}`,
+			expectedStatusCode:  http.StatusNoContent,
+			expectedBlockedUris: []string{"https://evil.example.com/x.js"},
+		},
+		{
+			method:              "POST",
+			body:                `[{"type":"csp-violation","body":{"documentURL":"https://example.com/","effectiveDirective":"img-src","blockedURL":"data"}},{"type":"deprecation","body":{}}]`,
+			expectedStatusCode:  http.StatusNoContent,
+			expectedBlockedUris: []string{"data"},
+		},
+		{
+			method:             "POST",
+			body:               `not json`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			method:             "GET",
+			expectedStatusCode: http.StatusMethodNotAllowed,
+		},
+	}
+	for i, testCase := range testCases {
+		var blockedUris []string
+		handler := CspReportHandler(func(req *http.Request, report CspReport) {
+			blockedUris = append(blockedUris, report.BlockedUri)
+		})
+		req, _ := http.NewRequest(testCase.method, "/csp", strings.NewReader(testCase.body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if expected, actual := testCase.expectedStatusCode, rec.Code; actual != expected {
+			t.Errorf("[i=%v] Expected status-code=%v but actual=%v", i, expected, actual)
+		}
+		if expected, actual := strings.Join(testCase.expectedBlockedUris, ","), strings.Join(blockedUris, ","); actual != expected {
+			t.Errorf("[i=%v] Expected blocked-uris=%q but actual=%q", i, expected, actual)
+		}
+	}
+}
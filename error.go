@@ -1,13 +1,44 @@
 package web
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 
 	"github.com/facebookgo/stack"
-	log "github.com/sirupsen/logrus"
 )
 
+// JsonError wraps detail in an error response body.  The detail is logged at
+// debug level only, since it frequently echoes request content.
 func JsonError(detail interface{}) Json {
+	detail = errorDetail(detail)
+	logger.Debugf("%v: JsonError: detail=%v\n", stack.Caller(1), detail)
+	return Json{"error": detail}
+}
+
+func JsonErrorf(format string, args ...interface{}) Json {
+	return JsonError(fmt.Sprintf(format, args...))
+}
+
+// JsonErrorFor is like JsonError, but additionally tags the log line and the
+// response body with the request id, if any.
+func JsonErrorFor(req *http.Request, detail interface{}) Json {
+	if err, ok := detail.(error); ok {
+		RecordError(req, err)
+	} else {
+		RecordError(req, errors.New(fmt.Sprint(detail)))
+	}
+	detail = errorDetail(detail)
+	RequestLogger(req).Debugf("%v: JsonError: detail=%v\n", stack.Caller(1), detail)
+	j := Json{"error": detail}
+	if id := RequestId(req); len(id) > 0 {
+		j["requestId"] = id
+	}
+	return j
+}
+
+func errorDetail(detail interface{}) interface{} {
 	switch detail.(type) {
 	case error:
 		detail = detail.(error).Error()
@@ -15,10 +46,30 @@ func JsonError(detail interface{}) Json {
 	default:
 		detail = fmt.Sprint(detail)
 	}
-	log.Errorf("%v: JsonError: detail=%v\n", stack.Caller(1), detail)
-	return Json{"error": detail}
+	return detail
 }
 
-func JsonErrorf(format string, args ...interface{}) Json {
-	return JsonError(fmt.Sprintf(format, args))
+// ErrorRecorder receives the errors reported for a request, e.g. to annotate
+// a trace span.
+type ErrorRecorder interface {
+	RecordError(err error)
+}
+
+// WithErrorRecorder returns a shallow copy of req which additionally reports
+// errors to recorder.  JsonErrorFor reports every error it's given.
+func WithErrorRecorder(req *http.Request, recorder ErrorRecorder) *http.Request {
+	recorders, _ := req.Context().Value(errorRecordersKey).([]ErrorRecorder)
+	recorders = append(recorders[:len(recorders):len(recorders)], recorder)
+	return req.WithContext(context.WithValue(req.Context(), errorRecordersKey, recorders))
+}
+
+// RecordError reports err to any recorders attached to req.
+func RecordError(req *http.Request, err error) {
+	if req == nil || err == nil {
+		return
+	}
+	recorders, _ := req.Context().Value(errorRecordersKey).([]ErrorRecorder)
+	for _, recorder := range recorders {
+		recorder.RecordError(err)
+	}
 }
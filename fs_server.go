@@ -7,7 +7,6 @@ import (
 
 	"gigawatt.io/errorlib"
 	"github.com/jaytaylor/stoppableListener"
-	log "github.com/sirupsen/logrus"
 )
 
 // FsServer is a filesystem server.
@@ -49,7 +48,7 @@ func (fsServer *FsServer) Start() error {
 	fsServer.listener = sl
 	go func() {
 		if err := fsServer.server.Serve(sl); err != nil && err != stoppableListener.StoppedError {
-			log.Errorf("unexpected error from FsServer.server.Serve(sl): %s", err)
+			logger.Errorf("unexpected error from FsServer.server.Serve(sl): %s", err)
 		}
 	}()
 	return nil
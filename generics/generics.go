@@ -8,7 +8,6 @@ import (
 	"github.com/gigawattio/errorlib"
 	"github.com/gigawattio/web"
 	"github.com/gigawattio/web/helper"
-	log "github.com/sirupsen/logrus"
 )
 
 var requestAlreadyHandledError = errors.New("already handled")
@@ -43,8 +42,8 @@ func GenericObjectEndpoint(w http.ResponseWriter, req *http.Request, processorFu
 		} else {
 			status = http.StatusInternalServerError
 		}
-		log.Errorf("%v: error running object processor on URI=%v status-code=%v: %s", stack.Caller(3), req.RequestURI, status, err)
-		web.RespondWithJson(w, status, web.JsonError(err))
+		web.RequestLogger(req).Errorf("%v: error running object processor on URI=%v status-code=%v: %s", stack.Caller(3), req.RequestURI, status, err)
+		web.RespondWithJson(w, status, web.JsonErrorFor(req, err))
 		return
 	}
 	if len(statuses) > 0 {
@@ -76,8 +75,8 @@ func GenericObjectsEndpoint(w http.ResponseWriter, req *http.Request, processorF
 		} else {
 			status = http.StatusInternalServerError
 		}
-		log.Errorf("%v: error running listing processor for URI=%v limit=%v offset=%v: %s", stack.Caller(3), req.RequestURI, limit, offset, err)
-		web.RespondWithJson(w, status, web.JsonError(err))
+		web.RequestLogger(req).Errorf("%v: error running listing processor for URI=%v limit=%v offset=%v: %s", stack.Caller(3), req.RequestURI, limit, offset, err)
+		web.RespondWithJson(w, status, web.JsonErrorFor(req, err))
 		return
 	}
 	response := NewApiResponse(objects, n)
@@ -0,0 +1,275 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHealthCheckTimeout bounds each check when HealthCheck.Timeout is
+	// 0.
+	DefaultHealthCheckTimeout = 2 * time.Second
+
+	// DefaultHealthCheckCacheTtl is how long results are reused when
+	// HealthCheck.CacheTtl is 0.  A negative CacheTtl disables caching.
+	DefaultHealthCheckCacheTtl = time.Second
+)
+
+var (
+	DuplicateHealthCheckError = errors.New("a health check with that name is already registered")
+	InvalidHealthCheckError   = errors.New("health checks require a name and a check function")
+	DrainingError             = errors.New("server is draining")
+)
+
+// HealthCheckKind selects which endpoints a check affects.
+type HealthCheckKind int
+
+const (
+	// ReadinessCheck failures mean the service shouldn't receive traffic
+	// right now, e.g. a dependency is down.  They affect /readyz and /healthz.
+	ReadinessCheck HealthCheckKind = iota
+
+	// LivenessCheck failures mean the process is wedged and should be
+	// restarted.  They affect /livez as well as /readyz and /healthz.
+	LivenessCheck
+)
+
+// HealthCheck is a named check which returns nil when healthy.  Checks
+// should honor ctx cancellation; those which don't are abandoned when their
+// timeout expires.
+type HealthCheck struct {
+	Name     string
+	Kind     HealthCheckKind
+	Check    func(ctx context.Context) error
+	Timeout  time.Duration // DefaultHealthCheckTimeout if 0.
+	CacheTtl time.Duration // DefaultHealthCheckCacheTtl if 0, no caching if negative.
+}
+
+// HealthCheckResult is the outcome of a single check.
+type HealthCheckResult struct {
+	Name      string    `json:"name"`
+	Healthy   bool      `json:"healthy"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// HealthReport is the outcome of a set of checks.
+type HealthReport struct {
+	Healthy bool                `json:"-"`
+	Status  string              `json:"status"`
+	Checks  []HealthCheckResult `json:"checks,omitempty"`
+}
+
+// HealthRegistry runs named health checks and serves their results.  Assign
+// one to WebServerOptions.Health to have readiness fail while the server
+// drains during Stop, and mount the handlers, e.g.:
+//
+//	{Reciever: "get", Path: "/livez", HandlerFunc: health.LivezHandler()},
+//	{Reciever: "get", Path: "/readyz", HandlerFunc: health.ReadyzHandler()},
+//	{Reciever: "get", Path: "/healthz", HandlerFunc: health.HealthzHandler()},
+//
+// Handlers answer 200 or 503 with {"status":"ok"} or {"status":"fail"}, and
+// include per-check detail when the "verbose" query parameter is present.
+type HealthRegistry struct {
+	checks   map[string]*registeredHealthCheck
+	draining bool
+	lock     sync.RWMutex
+}
+
+type registeredHealthCheck struct {
+	HealthCheck
+	result HealthCheckResult
+	cached bool
+	lock   sync.Mutex
+}
+
+// NewHealthRegistry creates an empty HealthRegistry.
+func NewHealthRegistry() *HealthRegistry {
+	registry := &HealthRegistry{
+		checks: map[string]*registeredHealthCheck{},
+	}
+	return registry
+}
+
+// Register adds a check.
+func (registry *HealthRegistry) Register(check HealthCheck) error {
+	if len(check.Name) == 0 || check.Check == nil {
+		return InvalidHealthCheckError
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = DefaultHealthCheckTimeout
+	}
+	if check.CacheTtl == 0 {
+		check.CacheTtl = DefaultHealthCheckCacheTtl
+	}
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, ok := registry.checks[check.Name]; ok {
+		return DuplicateHealthCheckError
+	}
+	registry.checks[check.Name] = &registeredHealthCheck{HealthCheck: check}
+	return nil
+}
+
+// SetDraining marks the service as (not) draining; while draining, readiness
+// fails regardless of the checks.
+func (registry *HealthRegistry) SetDraining(draining bool) {
+	registry.lock.Lock()
+	registry.draining = draining
+	registry.lock.Unlock()
+}
+
+// Draining reports whether the service is draining.
+func (registry *HealthRegistry) Draining() bool {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	return registry.draining
+}
+
+// Liveness runs the liveness checks.
+func (registry *HealthRegistry) Liveness(ctx context.Context) HealthReport {
+	return registry.run(ctx, func(check *registeredHealthCheck) bool {
+		return check.Kind == LivenessCheck
+	}, false)
+}
+
+// Readiness runs every check, and fails while draining.
+func (registry *HealthRegistry) Readiness(ctx context.Context) HealthReport {
+	return registry.run(ctx, func(*registeredHealthCheck) bool { return true }, true)
+}
+
+// LivezHandler serves Liveness.
+func (registry *HealthRegistry) LivezHandler() http.HandlerFunc {
+	return registry.handler(registry.Liveness)
+}
+
+// ReadyzHandler serves Readiness.
+func (registry *HealthRegistry) ReadyzHandler() http.HandlerFunc {
+	return registry.handler(registry.Readiness)
+}
+
+// HealthzHandler serves the overall health, which is the same as Readiness.
+func (registry *HealthRegistry) HealthzHandler() http.HandlerFunc {
+	return registry.handler(registry.Readiness)
+}
+
+func (registry *HealthRegistry) handler(report func(ctx context.Context) HealthReport) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		result := report(req.Context())
+		if _, verbose := req.URL.Query()["verbose"]; !verbose {
+			result.Checks = nil
+		}
+		status := http.StatusOK
+		if !result.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		RespondWithJson(w, status, result)
+	}
+}
+
+func (registry *HealthRegistry) run(ctx context.Context, include func(*registeredHealthCheck) bool, includeDraining bool) HealthReport {
+	registry.lock.RLock()
+	var checks []*registeredHealthCheck
+	for _, check := range registry.checks {
+		if include(check) {
+			checks = append(checks, check)
+		}
+	}
+	draining := registry.draining
+	registry.lock.RUnlock()
+
+	results := make([]HealthCheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check *registeredHealthCheck) {
+			defer wg.Done()
+			results[i] = check.run(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	if includeDraining && draining {
+		results = append(results, HealthCheckResult{
+			Name:      "draining",
+			Error:     DrainingError.Error(),
+			Duration:  "0s",
+			CheckedAt: time.Now(),
+		})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	report := HealthReport{
+		Healthy: true,
+		Status:  "ok",
+		Checks:  results,
+	}
+	for _, result := range results {
+		if !result.Healthy {
+			report.Healthy = false
+			report.Status = "fail"
+		}
+	}
+	return report
+}
+
+// run executes the check, or reuses a fresh cached result.  Concurrent
+// probes of the same check wait for a single execution.
+func (check *registeredHealthCheck) run(ctx context.Context) HealthCheckResult {
+	check.lock.Lock()
+	defer check.lock.Unlock()
+
+	if check.cached && check.CacheTtl > 0 && time.Since(check.result.CheckedAt) < check.CacheTtl {
+		return check.result
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+
+	started := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				errCh <- fmt.Errorf("panic: %v", p)
+			}
+		}()
+		errCh <- check.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		if parent.Err() != nil {
+			// The probe itself gave up, e.g. the client went away.
+			err = fmt.Errorf("abandoned: %s", parent.Err())
+		} else {
+			err = fmt.Errorf("timed out after %s", check.Timeout)
+		}
+	}
+
+	result := HealthCheckResult{
+		Name:      check.Name,
+		Healthy:   err == nil,
+		Duration:  time.Since(started).String(),
+		CheckedAt: started,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if parent.Err() == nil {
+		// Don't let a probe which gave up early poison the cache.
+		check.result = result
+		check.cached = true
+	}
+	return result
+}
@@ -0,0 +1,188 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthRegistry(t *testing.T) {
+	var (
+		registry = NewHealthRegistry()
+		dbErr    atomic.Value
+		dbCalls  int64
+	)
+	dbErr.Store("")
+	checks := []HealthCheck{
+		{
+			Name: "database",
+			Check: func(ctx context.Context) error {
+				atomic.AddInt64(&dbCalls, 1)
+				if msg := dbErr.Load().(string); len(msg) > 0 {
+					return errors.New(msg)
+				}
+				return nil
+			},
+			CacheTtl: -1,
+		},
+		{
+			Name:  "goroutines",
+			Kind:  LivenessCheck,
+			Check: func(ctx context.Context) error { return nil },
+		},
+	}
+	for _, check := range checks {
+		if err := registry.Register(check); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected, actual := DuplicateHealthCheckError, registry.Register(checks[0]); actual != expected {
+		t.Errorf("Expected err=%v but actual=%v", expected, actual)
+	}
+	if expected, actual := InvalidHealthCheckError, registry.Register(HealthCheck{Name: "nil"}); actual != expected {
+		t.Errorf("Expected err=%v but actual=%v", expected, actual)
+	}
+
+	probe := func(handler http.HandlerFunc, target string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", target, nil))
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %s", target, err)
+		}
+		return rec.Code, body
+	}
+
+	testCases := []struct {
+		dbErr            string
+		draining         bool
+		handler          http.HandlerFunc
+		expectedStatus   int
+		expectedChecks   int
+		expectedBodyOnly bool
+	}{
+		{"", false, registry.ReadyzHandler(), http.StatusOK, 2, false},
+		{"", false, registry.HealthzHandler(), http.StatusOK, 2, true},
+		{"connection refused", false, registry.ReadyzHandler(), http.StatusServiceUnavailable, 2, false},
+		{"connection refused", false, registry.LivezHandler(), http.StatusOK, 1, false},
+		{"", true, registry.ReadyzHandler(), http.StatusServiceUnavailable, 3, false},
+		{"", true, registry.LivezHandler(), http.StatusOK, 1, false},
+	}
+	for i, testCase := range testCases {
+		dbErr.Store(testCase.dbErr)
+		registry.SetDraining(testCase.draining)
+		target := "/?verbose"
+		if testCase.expectedBodyOnly {
+			target = "/"
+		}
+		status, body := probe(testCase.handler, target)
+		if expected, actual := testCase.expectedStatus, status; actual != expected {
+			t.Errorf("[i=%v] Expected status-code=%v but actual=%v body=%v", i, expected, actual, body)
+		}
+		expectedStatusText := "ok"
+		if testCase.expectedStatus != http.StatusOK {
+			expectedStatusText = "fail"
+		}
+		if expected, actual := expectedStatusText, body["status"]; actual != expected {
+			t.Errorf("[i=%v] Expected status=%q but actual=%v", i, expected, actual)
+		}
+		checks, _ := body["checks"].([]interface{})
+		if testCase.expectedBodyOnly {
+			if checks != nil {
+				t.Errorf("[i=%v] Expected no check detail without verbose but actual=%v", i, checks)
+			}
+		} else if expected, actual := testCase.expectedChecks, len(checks); actual != expected {
+			t.Errorf("[i=%v] Expected %v checks in detail but actual=%v", i, expected, actual)
+		}
+	}
+	if atomic.LoadInt64(&dbCalls) < 4 {
+		t.Errorf("Expected uncached check to run on every readiness probe but calls=%v", dbCalls)
+	}
+}
+
+func TestHealthCheckTimeoutAndCache(t *testing.T) {
+	registry := NewHealthRegistry()
+	var calls int64
+	registry.Register(HealthCheck{
+		Name:    "stuck",
+		Timeout: 10 * time.Millisecond,
+		Check: func(ctx context.Context) error {
+			atomic.AddInt64(&calls, 1)
+			time.Sleep(time.Second)
+			return nil
+		},
+		CacheTtl: time.Minute,
+	})
+	for i := 0; i < 3; i++ {
+		report := registry.Readiness(context.Background())
+		if report.Healthy || len(report.Checks) != 1 || report.Checks[0].Error != "timed out after 10ms" {
+			t.Fatalf("[i=%v] Expected timed out check but actual=%+v", i, report)
+		}
+	}
+	if expected, actual := int64(1), atomic.LoadInt64(&calls); actual != expected {
+		t.Errorf("Expected cached result to be reused with calls=%v but actual=%v", expected, actual)
+	}
+}
+
+func TestHealthCheckParentCancelled(t *testing.T) {
+	registry := NewHealthRegistry()
+	registry.Register(HealthCheck{
+		Name:    "slow",
+		Timeout: time.Minute,
+		Check: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	report := registry.Readiness(ctx)
+	if expected := "abandoned: " + context.DeadlineExceeded.Error(); report.Healthy || len(report.Checks) != 1 || report.Checks[0].Error != expected {
+		t.Errorf("Expected check error=%q but actual=%+v", expected, report)
+	}
+}
+
+func TestWebServerDrainsHealth(t *testing.T) {
+	registry := NewHealthRegistry()
+	server := NewWebServer(WebServerOptions{
+		Addr:       testAddr,
+		Handler:    registry.ReadyzHandler(),
+		Health:     registry,
+		DrainDelay: 300 * time.Millisecond,
+	})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	get := func() int {
+		resp, err := http.Get(fmt.Sprintf("http://%s/readyz", server.Addr()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if expected, actual := http.StatusOK, get(); actual != expected {
+		t.Fatalf("Expected readiness status-code=%v before Stop but actual=%v", expected, actual)
+	}
+	stopped := make(chan error)
+	go func() {
+		stopped <- server.Stop()
+	}()
+	for deadline := time.Now().Add(time.Second); !registry.Draining(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for Stop to begin draining")
+		}
+	}
+	if expected, actual := http.StatusServiceUnavailable, get(); actual != expected {
+		t.Errorf("Expected readiness status-code=%v while draining but actual=%v", expected, actual)
+	}
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+}
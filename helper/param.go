@@ -5,10 +5,12 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/gigawattio/web/logging"
 	"github.com/nbio/hitch"
-	log "github.com/sirupsen/logrus"
 )
 
+var logger = logging.For("helper")
+
 // ContextParam is a shortcut to get param values encoded in the url path.
 // e.g. the "id" portion of /v1/apps/:id.
 func ContextParam(name string, req *http.Request) string {
@@ -21,7 +23,7 @@ func Int64ContextParam(name string, req *http.Request) (int64, error) {
 	paramString := hitch.Params(req).ByName(name)
 	value, err := strconv.ParseInt(paramString, 10, 64)
 	if err != nil {
-		log.Infof("Failed to parse paramString=%s into an int64: %s", paramString, err)
+		logger.Infof("Failed to parse paramString=%s into an int64: %s", paramString, err)
 		return 0, fmt.Errorf("param lookup of '%v': %s", name, err)
 	}
 	return value, nil
@@ -0,0 +1,274 @@
+package web
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gigawatt.io/errorlib"
+)
+
+// DefaultIpFilterWatchInterval is how often an IpFilter checks its rules file
+// for changes.
+const DefaultIpFilterWatchInterval = 2 * time.Second
+
+// IpRule is a single allow or deny rule.
+type IpRule struct {
+	Allow   bool
+	Network *net.IPNet
+}
+
+// IpFilterOptions configures IpFilter.
+//
+// Rules are written one per line as "allow <cidr-or-ip>" or "deny
+// <cidr-or-ip>", where "all" matches every address.  Blank lines and lines
+// starting with # are ignored.  The first matching rule wins; requests
+// matching no rule are denied unless DefaultAllow is set.
+type IpFilterOptions struct {
+	Rules         []string      // inline rules, evaluated before those in File.
+	File          string        // optional rules file.
+	WatchInterval time.Duration // how often Start polls File, DefaultIpFilterWatchInterval if 0.
+	DefaultAllow  bool          // outcome when no rule matches.
+	DeniedHandler http.Handler  // answers denied requests, a 403 JsonError if nil.
+}
+
+// IpFilter is a middleware which admits or rejects requests based on the
+// client IP (see ClientIp), e.g.:
+//
+//	filter, err := web.NewIpFilter(web.IpFilterOptions{
+//		Rules: []string{"allow 10.8.0.0/16", "allow 2001:db8::/32"},
+//	})
+//	...
+//	route.RouteMiddlewareBundle{
+//		Middlewares: []func(http.Handler) http.Handler{filter.Middleware},
+//		RouteData:   adminRoutes,
+//	}
+//
+// Once started, rules loaded from a file are reloaded whenever the file
+// changes; when a reload fails the previous rules stay in force.
+type IpFilter struct {
+	options  IpFilterOptions
+	rules    atomic.Value // []IpRule
+	modTime  time.Time
+	size     int64
+	stopChan chan struct{}
+	lock     sync.Mutex
+}
+
+// NewIpFilter parses the rules and returns a ready to use IpFilter.
+func NewIpFilter(options IpFilterOptions) (*IpFilter, error) {
+	if options.WatchInterval <= 0 {
+		options.WatchInterval = DefaultIpFilterWatchInterval
+	}
+	filter := &IpFilter{
+		options: options,
+	}
+	if err := filter.Reload(); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// ParseIpRules parses rules in the format described by IpFilterOptions.
+func ParseIpRules(r io.Reader) ([]IpRule, error) {
+	var (
+		rules   []IpRule
+		scanner = bufio.NewScanner(r)
+		lineNo  int
+	)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseIpRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %s", lineNo, err)
+		}
+		rules = append(rules, rule...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func parseIpRule(line string) ([]IpRule, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("expected \"allow|deny <cidr>\" but found %q", line)
+	}
+	var allow bool
+	switch strings.ToLower(fields[0]) {
+	case "allow":
+		allow = true
+	case "deny":
+	default:
+		return nil, fmt.Errorf("unrecognized action %q", fields[0])
+	}
+	cidrs := []string{fields[1]}
+	if strings.ToLower(fields[1]) == "all" {
+		cidrs = []string{"0.0.0.0/0", "::/0"}
+	}
+	ipNets, err := parseCidrs(cidrs)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]IpRule, len(ipNets))
+	for i, ipNet := range ipNets {
+		rules[i] = IpRule{Allow: allow, Network: ipNet}
+	}
+	return rules, nil
+}
+
+// Allowed reports whether the rules admit ip.
+func (filter *IpFilter) Allowed(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, rule := range filter.rules.Load().([]IpRule) {
+		if rule.Network.Contains(parsed) {
+			return rule.Allow
+		}
+	}
+	return filter.options.DefaultAllow
+}
+
+// Middleware satisfies the MiddlewareFunc signature.
+func (filter *IpFilter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ip := ClientIp(req)
+		if filter.Allowed(ip) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		RequestLogger(req).Infof("web.IpFilter: denied %s %s from ip=%s", req.Method, req.RequestURI, ip)
+		if filter.options.DeniedHandler != nil {
+			filter.options.DeniedHandler.ServeHTTP(w, req)
+			return
+		}
+		RespondWithJson(w, http.StatusForbidden, JsonErrorFor(req, http.StatusText(http.StatusForbidden)))
+	})
+}
+
+// Reload unconditionally re-parses the rules, including the rules file.
+func (filter *IpFilter) Reload() error {
+	rules, err := ParseIpRules(strings.NewReader(strings.Join(filter.options.Rules, "\n")))
+	if err != nil {
+		return err
+	}
+	var (
+		modTime time.Time
+		size    int64
+	)
+	if len(filter.options.File) > 0 {
+		f, err := os.Open(filter.options.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		modTime, size = info.ModTime(), info.Size()
+		fileRules, err := ParseIpRules(f)
+		if err != nil {
+			return fmt.Errorf("%s: %s", filter.options.File, err)
+		}
+		rules = append(rules, fileRules...)
+	}
+	filter.rules.Store(rules)
+	filter.lock.Lock()
+	filter.modTime = modTime
+	filter.size = size
+	filter.lock.Unlock()
+	return nil
+}
+
+// Start begins polling the rules file for changes.
+func (filter *IpFilter) Start() error {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	if filter.stopChan != nil {
+		return errorlib.AlreadyRunningError
+	}
+	filter.stopChan = make(chan struct{})
+	go filter.watch(filter.stopChan)
+	return nil
+}
+
+// Stop terminates polling.
+func (filter *IpFilter) Stop() error {
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	if filter.stopChan == nil {
+		return errorlib.NotRunningError
+	}
+	close(filter.stopChan)
+	filter.stopChan = nil
+	return nil
+}
+
+func (filter *IpFilter) watch(stopChan chan struct{}) {
+	if len(filter.options.File) == 0 {
+		return
+	}
+	ticker := time.NewTicker(filter.options.WatchInterval)
+	defer ticker.Stop()
+	var (
+		retry          bool   // The last reload failed, e.g. due to a partially written file.
+		lastError      string // Each distinct failure is only logged once.
+		pendingModTime time.Time
+		pendingSize    int64 = -1
+	)
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(filter.options.File)
+			if err != nil {
+				logger.Errorf("web.IpFilter: error checking rules file %s: %s", filter.options.File, err)
+				continue
+			}
+			filter.lock.Lock()
+			changed := !info.ModTime().Equal(filter.modTime) || info.Size() != filter.size
+			filter.lock.Unlock()
+			if !changed && !retry {
+				continue
+			}
+			// Only load a revision which looks the same on consecutive ticks,
+			// since a file caught mid-write, e.g. just truncated, may parse as
+			// valid but incomplete rules.
+			if !info.ModTime().Equal(pendingModTime) || info.Size() != pendingSize {
+				pendingModTime, pendingSize = info.ModTime(), info.Size()
+				continue
+			}
+			// Keep retrying after a failure rather than waiting for the mtime
+			// to move on, since the completed file may share the mtime of the
+			// partial one which failed.
+			if err := filter.Reload(); err != nil {
+				retry = true
+				if err.Error() != lastError {
+					logger.Errorf("web.IpFilter: error reloading rules file %s, keeping previous rules: %s", filter.options.File, err)
+					lastError = err.Error()
+				}
+				continue
+			}
+			retry = false
+			lastError = ""
+		}
+	}
+}
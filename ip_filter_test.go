@@ -0,0 +1,166 @@
+package web
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIpFilter(t *testing.T) {
+	filter, err := NewIpFilter(IpFilterOptions{
+		Rules: []string{
+			"# office",
+			"deny 10.8.66.0/24",
+			"allow 10.8.0.0/16",
+			"allow 2001:db8::/32",
+			"allow 192.0.2.10",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := filter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	testCases := []struct {
+		remoteAddr string
+		expected   int
+	}{
+		{"10.8.1.2:1234", http.StatusNoContent},
+		{"10.8.66.2:1234", http.StatusForbidden},
+		{"10.9.0.1:1234", http.StatusForbidden},
+		{"[2001:db8::5]:443", http.StatusNoContent},
+		{"[2001:db9::5]:443", http.StatusForbidden},
+		{"192.0.2.10:80", http.StatusNoContent},
+		{"192.0.2.11:80", http.StatusForbidden},
+		{"garbage", http.StatusForbidden},
+	}
+	for i, testCase := range testCases {
+		rec := httptest.NewRecorder()
+		req := httptestRequest()
+		req.RemoteAddr = testCase.remoteAddr
+		handler.ServeHTTP(rec, req)
+		if expected, actual := testCase.expected, rec.Code; actual != expected {
+			t.Errorf("[i=%v] Expected status-code=%v for remoteAddr=%q but actual=%v", i, expected, testCase.remoteAddr, actual)
+		}
+	}
+}
+
+func TestIpFilterDefaultAllowAndDeniedHandler(t *testing.T) {
+	filter, err := NewIpFilter(IpFilterOptions{
+		Rules:        []string{"deny 203.0.113.0/24"},
+		DefaultAllow: true,
+		DeniedHandler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.Allowed("198.51.100.1") {
+		t.Errorf("Expected unmatched ip to be allowed with DefaultAllow")
+	}
+	rec := httptest.NewRecorder()
+	req := httptestRequest()
+	req.RemoteAddr = "203.0.113.4:1"
+	filter.Middleware(http.NotFoundHandler()).ServeHTTP(rec, req)
+	if expected, actual := http.StatusTeapot, rec.Code; actual != expected {
+		t.Errorf("Expected DeniedHandler status-code=%v but actual=%v", expected, actual)
+	}
+}
+
+func TestIpFilterFileReload(t *testing.T) {
+	f, err := ioutil.TempFile("", "ip-filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+	if err := ioutil.WriteFile(f.Name(), []byte("allow 10.0.0.1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewIpFilter(IpFilterOptions{File: f.Name(), WatchInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer filter.Stop()
+	if !filter.Allowed("10.0.0.1") || filter.Allowed("10.0.0.2") {
+		t.Fatalf("Expected only 10.0.0.1 to be allowed by initial rules")
+	}
+
+	if err := ioutil.WriteFile(f.Name(), []byte("allow all\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(f.Name(), time.Now(), time.Now().Add(time.Second))
+	for deadline := time.Now().Add(2 * time.Second); !filter.Allowed("10.0.0.2"); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for rules file reload")
+		}
+	}
+
+	// A broken revision leaves the previous rules in force.
+	if err := ioutil.WriteFile(f.Name(), []byte("permit everyone\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.Reload(); err == nil {
+		t.Errorf("Expected error reloading invalid rules but actual=<nil>")
+	}
+	if !filter.Allowed("10.0.0.2") {
+		t.Errorf("Expected previous rules to stay in force after failed reload")
+	}
+}
+
+// TestIpFilterFileReloadSameModTime ensures a rules file which is completed
+// within the same mtime tick as a partial write which failed to parse is still
+// picked up.
+func TestIpFilterFileReloadSameModTime(t *testing.T) {
+	f, err := ioutil.TempFile("", "ip-filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+	write := func(rules string, modTime time.Time) {
+		if err := ioutil.WriteFile(f.Name(), []byte(rules), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(f.Name(), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("allow 10.0.0.1\n", time.Now().Add(-time.Hour))
+	filter, err := NewIpFilter(IpFilterOptions{File: f.Name(), WatchInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer filter.Stop()
+
+	// Same size and mtime, so only retrying after the failure can notice.
+	modTime := time.Now()
+	write("allow 10.0", modTime)
+	time.Sleep(50 * time.Millisecond) // Let the watcher fail on the partial file.
+	write("allow all\n", modTime)
+
+	for deadline := time.Now().Add(2 * time.Second); !filter.Allowed("10.0.0.2"); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the completed rules file to be loaded")
+		}
+	}
+}
+
+func TestParseIpRulesInvalid(t *testing.T) {
+	for i, rules := range []string{"allow", "permit 10.0.0.0/8", "deny 10.0.0.0/40", "allow 1.2.3.4 extra"} {
+		if _, err := NewIpFilter(IpFilterOptions{Rules: []string{rules}}); err == nil {
+			t.Errorf("[i=%v] Expected error for rules=%q but actual=<nil>", i, rules)
+		}
+	}
+}
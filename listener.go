@@ -0,0 +1,185 @@
+package web
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gigawattio/web/systemd"
+	"github.com/gigawattio/web/upgrade"
+	"github.com/jaytaylor/stoppableListener"
+)
+
+// UnixSocketPrefix marks a WebServerOptions.Addr as a Unix domain socket path,
+// e.g. "unix:/run/myservice/http.sock".
+const UnixSocketPrefix = "unix:"
+
+// SystemdPrefix marks a WebServerOptions.Addr as a socket passed by systemd
+// socket activation.  "systemd:" claims the first socket, "systemd:name" the
+// one with FileDescriptorName=name in the .socket unit.
+const SystemdPrefix = "systemd:"
+
+var (
+	SocketInUseError = errors.New("unix socket is in use by another process")
+	NotASocketError  = errors.New("path exists and is not a unix socket")
+)
+
+// UnixSocketOptions configures the socket file created for a "unix:" Addr.
+type UnixSocketOptions struct {
+	Mode  os.FileMode // permissions applied after creation, e.g. 0660; left to the umask if 0.
+	User  string      // optional owner, as a user name or uid.
+	Group string      // optional group, as a group name or gid.
+}
+
+// stoppable is a listener which WebServer knows how to shut down.
+type stoppable interface {
+	net.Listener
+	StopSafely() error
+}
+
+// listen opens the listener described by the options, preferring one
+// inherited from the previous process during an upgrade.
+func listen(options WebServerOptions) (net.Listener, error) {
+	if options.Listener != nil {
+		return options.Listener, nil
+	}
+	if l, ok := upgrade.Inherited(options.Addr); ok {
+		logger.Infof("web.WebServer: adopted listener for addr=%s from the previous process", options.Addr)
+		return l, nil
+	}
+	switch {
+	case strings.HasPrefix(options.Addr, SystemdPrefix):
+		return systemd.Listener(strings.TrimPrefix(options.Addr, SystemdPrefix))
+	case strings.HasPrefix(options.Addr, UnixSocketPrefix):
+		return listenUnix(strings.TrimPrefix(options.Addr, UnixSocketPrefix), options.UnixSocket)
+	default:
+		return net.Listen("tcp", options.Addr)
+	}
+}
+
+// makeStoppable wraps l so that WebServer can stop it.
+func makeStoppable(l net.Listener) (stoppable, error) {
+	if _, ok := l.(*net.TCPListener); ok {
+		sl, err := stoppableListener.New(l)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		return sl, nil
+	}
+	return &closingListener{Listener: l}, nil
+}
+
+// listenUnix creates a Unix domain socket, first removing any stale socket
+// file left behind by a process which exited without cleaning up.
+func listenUnix(path string, options UnixSocketOptions) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := applySocketOptions(path, options); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return NotASocketError
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return SocketInUseError
+	}
+	logger.Infof("web.WebServer: removing stale unix socket %s", path)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func applySocketOptions(path string, options UnixSocketOptions) error {
+	if options.Mode != 0 {
+		if err := os.Chmod(path, options.Mode); err != nil {
+			return err
+		}
+	}
+	if len(options.User) == 0 && len(options.Group) == 0 {
+		return nil
+	}
+	uid, gid := -1, -1
+	if len(options.User) > 0 {
+		id, err := lookupId(options.User, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return err
+		}
+		uid = id
+	}
+	if len(options.Group) > 0 {
+		id, err := lookupId(options.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return err
+		}
+		gid = id
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// lookupId accepts either a numeric id or a name to resolve.
+func lookupId(nameOrId string, lookup func(name string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(nameOrId); err == nil {
+		return id, nil
+	}
+	idString, err := lookup(nameOrId)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(idString)
+}
+
+// closingListener makes listeners which stoppableListener can't wrap, e.g.
+// Unix sockets and listeners of unknown type, stoppable.
+type closingListener struct {
+	net.Listener
+	stopped int32
+}
+
+func (l *closingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil && atomic.LoadInt32(&l.stopped) == 1 {
+		return nil, stoppableListener.StoppedError
+	}
+	return conn, err
+}
+
+func (l *closingListener) StopSafely() error {
+	atomic.StoreInt32(&l.stopped, 1)
+	return l.Listener.Close()
+}
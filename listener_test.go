@@ -0,0 +1,166 @@
+package web
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func unixSocketClient(path string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+func helloHandler(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte("hello"))
+}
+
+func getBody(t *testing.T, client *http.Client, url string) string {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func tempSocketPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "web-unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "http.sock"), func() { os.RemoveAll(dir) }
+}
+
+func TestWebServerUnixSocket(t *testing.T) {
+	path, cleanup := tempSocketPath(t)
+	defer cleanup()
+
+	ws := NewWebServer(WebServerOptions{
+		Addr:       UnixSocketPrefix + path,
+		Handler:    http.HandlerFunc(helloHandler),
+		UnixSocket: UnixSocketOptions{Mode: 0600, Group: strconv.Itoa(os.Getgid())},
+	})
+	if err := ws.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := os.FileMode(0600), info.Mode().Perm(); actual != expected {
+		t.Errorf("Expected socket mode=%v but actual=%v", expected, actual)
+	}
+	if expected, actual := path, ws.Addr().String(); actual != expected {
+		t.Errorf("Expected Addr()=%q but actual=%q", expected, actual)
+	}
+	if expected, actual := "http+unix://"+strings.Replace(path, "/", "%2F", -1), ws.BaseUrl(); actual != expected {
+		t.Errorf("Expected BaseUrl()=%q but actual=%q", expected, actual)
+	}
+	if expected, actual := "hello", getBody(t, unixSocketClient(path), "http://unix/"); actual != expected {
+		t.Errorf("Expected body=%q but actual=%q", expected, actual)
+	}
+
+	// A second server must not steal a live socket.
+	if err := NewWebServer(WebServerOptions{Addr: UnixSocketPrefix + path}).Start(); err != SocketInUseError {
+		t.Errorf("Expected err=%v but actual=%v", SocketInUseError, err)
+	}
+
+	if err := ws.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected socket file to be removed by Stop but stat err=%v", err)
+	}
+}
+
+func TestWebServerUnixSocketStale(t *testing.T) {
+	path, cleanup := tempSocketPath(t)
+	defer cleanup()
+
+	// Leave a socket file behind, as a crashed process would.
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	ws := NewWebServer(WebServerOptions{Addr: UnixSocketPrefix + path, Handler: http.HandlerFunc(helloHandler)})
+	if err := ws.Start(); err != nil {
+		t.Fatalf("Expected stale socket to be replaced but got err=%v", err)
+	}
+	defer ws.Stop()
+	if expected, actual := "hello", getBody(t, unixSocketClient(path), "http://unix/"); actual != expected {
+		t.Errorf("Expected body=%q but actual=%q", expected, actual)
+	}
+}
+
+func TestWebServerUnixSocketNotASocket(t *testing.T) {
+	path, cleanup := tempSocketPath(t)
+	defer cleanup()
+
+	if err := ioutil.WriteFile(path, []byte("important"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewWebServer(WebServerOptions{Addr: UnixSocketPrefix + path}).Start(); err != NotASocketError {
+		t.Errorf("Expected err=%v but actual=%v", NotASocketError, err)
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != "important" {
+		t.Errorf("Expected regular file to be left alone but content=%q", string(content))
+	}
+}
+
+// wrappedListener hides the concrete listener type.
+type wrappedListener struct {
+	net.Listener
+}
+
+func TestWebServerInjectedListener(t *testing.T) {
+	testCases := []func() (net.Listener, error){
+		func() (net.Listener, error) { return net.Listen("tcp", testAddr) },
+		func() (net.Listener, error) {
+			l, err := net.Listen("tcp", testAddr)
+			return wrappedListener{l}, err
+		},
+	}
+	for i, newListener := range testCases {
+		l, err := newListener()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws := NewWebServer(WebServerOptions{Listener: l, Handler: http.HandlerFunc(helloHandler)})
+		if err := ws.Start(); err != nil {
+			t.Fatalf("[i=%v] %s", i, err)
+		}
+		if expected, actual := l.Addr().String(), ws.Addr().String(); actual != expected {
+			t.Errorf("[i=%v] Expected Addr()=%q but actual=%q", i, expected, actual)
+		}
+		if expected, actual := "hello", getBody(t, http.DefaultClient, ws.BaseUrl()); actual != expected {
+			t.Errorf("[i=%v] Expected body=%q but actual=%q", i, expected, actual)
+		}
+		if err := ws.Stop(); err != nil {
+			t.Fatalf("[i=%v] %s", i, err)
+		}
+		if conn, err := net.Dial("tcp", l.Addr().String()); err == nil {
+			conn.Close()
+			t.Errorf("[i=%v] Expected injected listener to be closed by Stop", i)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package web
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gigawattio/web/logging"
+)
+
+// logger is the "web" subsystem logger, see the logging package.
+var logger = logging.For("web")
+
+// WithLogger returns a shallow copy of req whose RequestLogger writes to l
+// instead of the global logging backend.
+func WithLogger(req *http.Request, l logging.Logger) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), loggerKey, l))
+}
+
+// LoggerMiddleware generates a middleware function which directs the request
+// logs of everything it wraps to l.  WebServer applies it automatically when
+// WebServerOptions.Logger is set.
+func LoggerMiddleware(l logging.Logger) MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, WithLogger(req, l))
+		})
+	}
+}
+
+// RequestLogger provides a "web" subsystem logger for the request, which
+// writes to the logger injected with WithLogger if any, and tags each line
+// with the request id when there is one.
+func RequestLogger(req *http.Request) logging.Logger {
+	l := logger
+	if req != nil {
+		if injected, ok := req.Context().Value(loggerKey).(logging.Logger); ok {
+			l = logging.Subsystem("web", injected)
+		}
+	}
+	if id := RequestId(req); len(id) > 0 {
+		l = l.With("requestId", id)
+	}
+	return l
+}
@@ -0,0 +1,99 @@
+package web
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gigawattio/web/logging"
+)
+
+type testLogger struct {
+	lines  *[]string
+	fields string
+	lock   *sync.Mutex
+}
+
+func newTestLogger() *testLogger {
+	return &testLogger{lines: &[]string{}, lock: &sync.Mutex{}}
+}
+
+func (l *testLogger) add(format string, args ...interface{}) {
+	l.lock.Lock()
+	*l.lines = append(*l.lines, fmt.Sprintf(format, args...)+l.fields)
+	l.lock.Unlock()
+}
+
+func (l *testLogger) Debugf(format string, args ...interface{}) { l.add(format, args...) }
+func (l *testLogger) Infof(format string, args ...interface{})  { l.add(format, args...) }
+func (l *testLogger) Warnf(format string, args ...interface{})  { l.add(format, args...) }
+func (l *testLogger) Errorf(format string, args ...interface{}) { l.add(format, args...) }
+
+func (l *testLogger) With(key string, value interface{}) logging.Logger {
+	return &testLogger{lines: l.lines, fields: fmt.Sprintf("%s %s=%v", l.fields, key, value), lock: l.lock}
+}
+
+func (l *testLogger) Lines() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]string(nil), *l.lines...)
+}
+
+func TestRequestLoggerUsesInjectedLogger(t *testing.T) {
+	injected := newTestLogger()
+	handler := LoggerMiddleware(injected)(RequestIdMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		RequestLogger(req).Infof("hello")
+	})))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIdHeader, "abc123")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if expected, actual := []string{"hello requestId=abc123"}, injected.Lines(); len(actual) != 1 || actual[0] != expected[0] {
+		t.Errorf("Expected lines=%q but actual=%q", expected, actual)
+	}
+}
+
+func TestWebServerLogger(t *testing.T) {
+	injected := newTestLogger()
+	ws := NewWebServer(WebServerOptions{
+		Addr: "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			RequestLogger(req).Warnf("handled %s", req.URL.Path)
+		}),
+		Logger: injected,
+	})
+	if err := ws.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Stop()
+
+	resp, err := http.Get(ws.BaseUrl() + "/logged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	lines := injected.Lines()
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "handled /logged") {
+		t.Errorf("Expected a single line from the handler but actual=%q", lines)
+	}
+}
+
+func TestJsonErrorLogsAtDebug(t *testing.T) {
+	injected := newTestLogger()
+	logging.SetLevel("web", logging.InfoLevel)
+	defer logging.ResetLevel("web")
+
+	req := WithLogger(httptest.NewRequest("GET", "/", nil), injected)
+	JsonErrorFor(req, "secret request content")
+
+	if actual := injected.Lines(); len(actual) != 0 {
+		t.Errorf("Expected JsonErrorFor detail to be suppressed above debug level but actual=%q", actual)
+	}
+}
@@ -0,0 +1,198 @@
+// Package logging defines the Logger interface used throughout this module,
+// along with adapters for logrus and log/slog, and per-subsystem levels.
+//
+// Packages obtain their logger with For, e.g. logging.For("route"), and log
+// through whichever backend has been installed with SetBackend (the logrus
+// standard logger by default).  Levels can be raised or lowered per
+// subsystem, where a subsystem without its own level inherits from its dotted
+// parent, e.g. "web.server" from "web":
+//
+//	logging.SetBackend(logging.NewSlog(slog.Default()))
+//	logging.SetLevel("web", logging.WarnLevel)
+//	logging.SetLevel("route", logging.DebugLevel)
+package logging
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logger is a leveled, structured logger.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+
+	// With returns a logger which adds the key/value pair to every line.
+	With(key string, value interface{}) Logger
+}
+
+// Level is a logging severity.
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+	OffLevel
+)
+
+var InvalidLevelError = errors.New("invalid log level, must be one of: debug, info, warn, error, off")
+
+var levelNames = []string{"debug", "info", "warn", "error", "off"}
+
+func (level Level) String() string {
+	if level < DebugLevel || level > OffLevel {
+		return "unknown"
+	}
+	return levelNames[level]
+}
+
+// ParseLevel parses a level name, e.g. "warn".
+func ParseLevel(s string) (Level, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "warning" {
+		s = "warn"
+	}
+	for i, name := range levelNames {
+		if name == s {
+			return Level(i), nil
+		}
+	}
+	return 0, InvalidLevelError
+}
+
+var (
+	backend Logger = NewLogrus(logrus.StandardLogger())
+	levels         = map[string]Level{}
+	// DebugLevel leaves filtering to the backend's own level.
+	defaultLevel = DebugLevel
+	lock         sync.RWMutex
+)
+
+// SetBackend installs the logger which all subsystems write to.
+func SetBackend(l Logger) {
+	lock.Lock()
+	backend = l
+	lock.Unlock()
+}
+
+// Backend returns the installed backend.
+func Backend() Logger {
+	lock.RLock()
+	defer lock.RUnlock()
+	return backend
+}
+
+// SetLevel sets the minimum level for a subsystem and its children.
+func SetLevel(subsystem string, level Level) {
+	lock.Lock()
+	levels[subsystem] = level
+	lock.Unlock()
+}
+
+// ResetLevel removes a subsystem's own level, so that it inherits again.
+func ResetLevel(subsystem string) {
+	lock.Lock()
+	delete(levels, subsystem)
+	lock.Unlock()
+}
+
+// SetDefaultLevel sets the level of subsystems without one of their own.
+func SetDefaultLevel(level Level) {
+	lock.Lock()
+	defaultLevel = level
+	lock.Unlock()
+}
+
+// LevelOf returns the effective level of a subsystem.
+func LevelOf(subsystem string) Level {
+	lock.RLock()
+	defer lock.RUnlock()
+	for name := subsystem; ; {
+		if level, ok := levels[name]; ok {
+			return level
+		}
+		i := strings.LastIndex(name, ".")
+		if i == -1 {
+			return defaultLevel
+		}
+		name = name[:i]
+	}
+}
+
+// Levels returns a copy of the explicitly configured subsystem levels.
+func Levels() map[string]Level {
+	lock.RLock()
+	defer lock.RUnlock()
+	copied := make(map[string]Level, len(levels))
+	for name, level := range levels {
+		copied[name] = level
+	}
+	return copied
+}
+
+// For returns the logger for a subsystem, which writes to the current
+// backend.
+func For(subsystem string) Logger {
+	return &subsystemLogger{subsystem: subsystem}
+}
+
+// Subsystem returns a logger for a subsystem which writes to the specified
+// backend instead of the global one, e.g. a logger injected into a WebServer.
+func Subsystem(subsystem string, l Logger) Logger {
+	return &subsystemLogger{subsystem: subsystem, backend: l}
+}
+
+// subsystemLogger applies the subsystem's level before delegating.
+type subsystemLogger struct {
+	subsystem string
+	backend   Logger // Global backend if nil.
+}
+
+func (l *subsystemLogger) target(level Level) Logger {
+	if level < LevelOf(l.subsystem) {
+		return nil
+	}
+	if l.backend != nil {
+		return l.backend
+	}
+	return Backend()
+}
+
+func (l *subsystemLogger) Debugf(format string, args ...interface{}) {
+	if target := l.target(DebugLevel); target != nil {
+		target.Debugf(format, args...)
+	}
+}
+
+func (l *subsystemLogger) Infof(format string, args ...interface{}) {
+	if target := l.target(InfoLevel); target != nil {
+		target.Infof(format, args...)
+	}
+}
+
+func (l *subsystemLogger) Warnf(format string, args ...interface{}) {
+	if target := l.target(WarnLevel); target != nil {
+		target.Warnf(format, args...)
+	}
+}
+
+func (l *subsystemLogger) Errorf(format string, args ...interface{}) {
+	if target := l.target(ErrorLevel); target != nil {
+		target.Errorf(format, args...)
+	}
+}
+
+func (l *subsystemLogger) With(key string, value interface{}) Logger {
+	b := l.backend
+	if b == nil {
+		b = Backend()
+	}
+	return &subsystemLogger{subsystem: l.subsystem, backend: b.With(key, value)}
+}
@@ -0,0 +1,184 @@
+package logging
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// captureLogger records every line it receives as "level: message key=value".
+type captureLogger struct {
+	lines  *[]string
+	fields string
+	lock   *sync.Mutex
+}
+
+func newCaptureLogger() *captureLogger {
+	return &captureLogger{lines: &[]string{}, lock: &sync.Mutex{}}
+}
+
+func (l *captureLogger) add(level string, format string, args ...interface{}) {
+	l.lock.Lock()
+	*l.lines = append(*l.lines, level+": "+fmt.Sprintf(format, args...)+l.fields)
+	l.lock.Unlock()
+}
+
+func (l *captureLogger) Debugf(format string, args ...interface{}) { l.add("debug", format, args...) }
+func (l *captureLogger) Infof(format string, args ...interface{})  { l.add("info", format, args...) }
+func (l *captureLogger) Warnf(format string, args ...interface{})  { l.add("warn", format, args...) }
+func (l *captureLogger) Errorf(format string, args ...interface{}) { l.add("error", format, args...) }
+
+func (l *captureLogger) With(key string, value interface{}) Logger {
+	return &captureLogger{lines: l.lines, fields: fmt.Sprintf("%s %s=%v", l.fields, key, value), lock: l.lock}
+}
+
+func (l *captureLogger) Lines() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]string(nil), *l.lines...)
+}
+
+// withCleanState installs a capture backend and resets all levels, returning
+// the backend and a function which restores the previous state.
+func withCleanState() (*captureLogger, func()) {
+	capture := newCaptureLogger()
+	previous := Backend()
+	SetBackend(capture)
+	resetLevels()
+	restore := func() {
+		SetBackend(previous)
+		resetLevels()
+	}
+	return capture, restore
+}
+
+func resetLevels() {
+	for name := range Levels() {
+		ResetLevel(name)
+	}
+	SetDefaultLevel(DebugLevel)
+}
+
+func TestParseLevel(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Level
+		name     string
+		err      error
+	}{
+		{input: "debug", expected: DebugLevel, name: "debug"},
+		{input: "INFO", expected: InfoLevel, name: "info"},
+		{input: " warn ", expected: WarnLevel, name: "warn"},
+		{input: "warning", expected: WarnLevel, name: "warn"},
+		{input: "error", expected: ErrorLevel, name: "error"},
+		{input: "off", expected: OffLevel, name: "off"},
+		{input: "verbose", err: InvalidLevelError},
+		{input: "", err: InvalidLevelError},
+	}
+	for i, testCase := range testCases {
+		level, err := ParseLevel(testCase.input)
+		if err != testCase.err {
+			t.Errorf("[i=%v] Expected err=%v but actual=%v", i, testCase.err, err)
+			continue
+		}
+		if err == nil && level != testCase.expected {
+			t.Errorf("[i=%v] Expected level=%v but actual=%v", i, testCase.expected, level)
+		}
+		if err == nil && level.String() != testCase.name {
+			t.Errorf("[i=%v] Expected level.String()=%q but actual=%q", i, testCase.name, level.String())
+		}
+	}
+}
+
+func TestLevelInheritance(t *testing.T) {
+	_, restore := withCleanState()
+	defer restore()
+
+	SetLevel("web", WarnLevel)
+	SetLevel("web.server.tls", ErrorLevel)
+
+	testCases := []struct {
+		subsystem string
+		expected  Level
+	}{
+		{subsystem: "web", expected: WarnLevel},
+		{subsystem: "web.server", expected: WarnLevel},
+		{subsystem: "web.server.tls", expected: ErrorLevel},
+		{subsystem: "webby", expected: DebugLevel},
+		{subsystem: "route", expected: DebugLevel},
+	}
+	for i, testCase := range testCases {
+		if actual := LevelOf(testCase.subsystem); actual != testCase.expected {
+			t.Errorf("[i=%v] Expected LevelOf(%q)=%v but actual=%v", i, testCase.subsystem, testCase.expected, actual)
+		}
+	}
+
+	SetDefaultLevel(InfoLevel)
+	if actual := LevelOf("route"); actual != InfoLevel {
+		t.Errorf("Expected default level=%v but actual=%v", InfoLevel, actual)
+	}
+	ResetLevel("web")
+	if actual := LevelOf("web.server"); actual != InfoLevel {
+		t.Errorf("Expected level=%v after reset but actual=%v", InfoLevel, actual)
+	}
+	if expected, actual := map[string]Level{"web.server.tls": ErrorLevel}, Levels(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected levels=%v but actual=%v", expected, actual)
+	}
+}
+
+func TestSubsystemFiltering(t *testing.T) {
+	capture, restore := withCleanState()
+	defer restore()
+
+	SetLevel("route", WarnLevel)
+	l := For("route")
+	l.Debugf("a")
+	l.Infof("b")
+	l.Warnf("c")
+	l.Errorf("d")
+	For("web").Debugf("e")
+	l.With("path", "/x").Infof("f")
+	l.With("path", "/x").Errorf("g")
+
+	SetLevel("route", OffLevel)
+	l.Errorf("h")
+
+	expected := []string{"warn: c", "error: d", "debug: e", "error: g path=/x"}
+	if actual := capture.Lines(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected lines=%q but actual=%q", expected, actual)
+	}
+}
+
+func TestSubsystemInjectedBackend(t *testing.T) {
+	global, restore := withCleanState()
+	defer restore()
+	injected := newCaptureLogger()
+
+	SetLevel("web", InfoLevel)
+	l := Subsystem("web.server", injected)
+	l.Debugf("filtered")
+	l.Infof("kept")
+	l.With("requestId", "abc").Warnf("tagged")
+
+	if expected, actual := []string{"info: kept", "warn: tagged requestId=abc"}, injected.Lines(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected injected lines=%q but actual=%q", expected, actual)
+	}
+	if actual := global.Lines(); len(actual) != 0 {
+		t.Errorf("Expected nothing written to the global backend but actual=%q", actual)
+	}
+}
+
+func TestSetBackendAfterFor(t *testing.T) {
+	_, restore := withCleanState()
+	defer restore()
+
+	l := For("tracing")
+	replacement := newCaptureLogger()
+	SetBackend(replacement)
+	l.Infof("hello")
+
+	if expected, actual := []string{"info: hello"}, replacement.Lines(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected lines=%q but actual=%q", expected, actual)
+	}
+}
@@ -0,0 +1,24 @@
+package logging
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// logrusLogger adapts a logrus logger or entry.
+type logrusLogger struct {
+	l logrus.FieldLogger
+}
+
+// NewLogrus adapts a logrus *Logger or *Entry.
+func NewLogrus(l logrus.FieldLogger) Logger {
+	return &logrusLogger{l: l}
+}
+
+func (l *logrusLogger) Debugf(format string, args ...interface{}) { l.l.Debugf(format, args...) }
+func (l *logrusLogger) Infof(format string, args ...interface{})  { l.l.Infof(format, args...) }
+func (l *logrusLogger) Warnf(format string, args ...interface{})  { l.l.Warnf(format, args...) }
+func (l *logrusLogger) Errorf(format string, args ...interface{}) { l.l.Errorf(format, args...) }
+
+func (l *logrusLogger) With(key string, value interface{}) Logger {
+	return &logrusLogger{l: l.l.WithField(key, value)}
+}
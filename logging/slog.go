@@ -0,0 +1,48 @@
+//go:build go1.21
+// +build go1.21
+
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// slogLogger adapts a log/slog logger.
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlog adapts a log/slog logger.
+func NewSlog(l *slog.Logger) Logger {
+	return &slogLogger{l: l}
+}
+
+func (l *slogLogger) log(level slog.Level, format string, args ...interface{}) {
+	ctx := context.Background()
+	if !l.l.Enabled(ctx, level) {
+		return
+	}
+	l.l.Log(ctx, level, fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Debugf(format string, args ...interface{}) {
+	l.log(slog.LevelDebug, format, args...)
+}
+
+func (l *slogLogger) Infof(format string, args ...interface{}) {
+	l.log(slog.LevelInfo, format, args...)
+}
+
+func (l *slogLogger) Warnf(format string, args ...interface{}) {
+	l.log(slog.LevelWarn, format, args...)
+}
+
+func (l *slogLogger) Errorf(format string, args ...interface{}) {
+	l.log(slog.LevelError, format, args...)
+}
+
+func (l *slogLogger) With(key string, value interface{}) Logger {
+	return &slogLogger{l: l.l.With(key, value)}
+}
@@ -0,0 +1,36 @@
+//go:build go1.21
+// +build go1.21
+
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogAdapter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewSlog(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	l.Debugf("hidden %v", 1)
+	l.Infof("shown %v", 2)
+	l.With("requestId", "abc").Errorf("failed: %s", "boom")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if expected, actual := 2, len(lines); actual != expected {
+		t.Fatalf("Expected %v lines but actual=%v: %q", expected, actual, lines)
+	}
+	expected := [][]string{
+		{"level=INFO", `msg="shown 2"`},
+		{"level=ERROR", `msg="failed: boom"`, "requestId=abc"},
+	}
+	for i, fragments := range expected {
+		for _, fragment := range fragments {
+			if !strings.Contains(lines[i], fragment) {
+				t.Errorf("[i=%v] Expected line to contain %q but actual=%q", i, fragment, lines[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gigawattio/web"
+)
+
+// UnmatchedRoute is the route label for requests which no route.RouteDatum
+// handled, e.g. 404s.  Labelling by raw path instead would allow clients to
+// create unbounded numbers of series.
+const UnmatchedRoute = "unmatched"
+
+// HttpOptions configures HttpMetrics.
+type HttpOptions struct {
+	Registry       *Registry // DefaultRegistry if nil.
+	Namespace      string    // optional metric name prefix, e.g. "myservice".
+	LatencyBuckets []float64 // DefaultLatencyBuckets if empty.
+	SizeBuckets    []float64 // DefaultSizeBuckets if empty.
+}
+
+// HttpMetrics instruments HTTP requests and, when ConnState is installed in
+// web.WebServerOptions, server connections:
+//
+//	httpMetrics := metrics.NewHttpMetrics(metrics.HttpOptions{Namespace: "myservice"})
+//	options := web.WebServerOptions{
+//		Handler:   httpMetrics.Middleware(router),
+//		ConnState: httpMetrics.ConnState,
+//	}
+//	// and somewhere in router:
+//	route.RouteDatum{Reciever: "get", Path: "/metrics", HandlerFunc: metrics.DefaultRegistry.Handler().ServeHTTP},
+//
+// Requests are labelled by method, route pattern (see web.RoutePattern) and
+// status class, e.g. "2xx".
+type HttpMetrics struct {
+	requests         *CounterVec
+	duration         *HistogramVec
+	responseSize     *HistogramVec
+	inFlight         *GaugeVec
+	connections      *GaugeVec
+	connectionsTotal *CounterVec
+	connStates       map[net.Conn]http.ConnState
+	lock             sync.Mutex
+}
+
+// NewHttpMetrics registers the HTTP metrics with the registry.
+func NewHttpMetrics(options HttpOptions) *HttpMetrics {
+	if options.Registry == nil {
+		options.Registry = DefaultRegistry
+	}
+	if len(options.LatencyBuckets) == 0 {
+		options.LatencyBuckets = DefaultLatencyBuckets
+	}
+	if len(options.SizeBuckets) == 0 {
+		options.SizeBuckets = DefaultSizeBuckets
+	}
+	prefix := ""
+	if len(options.Namespace) > 0 {
+		prefix = options.Namespace + "_"
+	}
+	r := options.Registry
+	m := &HttpMetrics{
+		requests:         r.NewCounterVec(prefix+"http_requests_total", "Total HTTP requests handled.", "method", "route", "status"),
+		duration:         r.NewHistogramVec(prefix+"http_request_duration_seconds", "HTTP request latency in seconds.", options.LatencyBuckets, "method", "route", "status"),
+		responseSize:     r.NewHistogramVec(prefix+"http_response_size_bytes", "HTTP response body size in bytes.", options.SizeBuckets, "method", "route", "status"),
+		inFlight:         r.NewGaugeVec(prefix+"http_requests_in_flight", "HTTP requests currently being handled.", "method"),
+		connections:      r.NewGaugeVec(prefix+"http_connections", "Current server connections by state.", "state"),
+		connectionsTotal: r.NewCounterVec(prefix+"http_connections_total", "Total server connections accepted."),
+		connStates:       map[net.Conn]http.ConnState{},
+	}
+	return m
+}
+
+// Middleware satisfies the web.MiddlewareFunc signature.  Apply it outside of
+// the router so that every request is counted.
+func (m *HttpMetrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var (
+			method   = methodLabel(req.Method)
+			inFlight = m.inFlight.With(method)
+			started  = time.Now()
+			tw       = web.NewTrackingResponseWriter(w)
+		)
+		req = web.TrackRoutePattern(req)
+		inFlight.Inc()
+		defer func() {
+			inFlight.Dec()
+			route := web.RoutePattern(req)
+			if len(route) == 0 {
+				route = UnmatchedRoute
+			}
+			status := tw.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			p := recover()
+			if p != nil {
+				status = http.StatusInternalServerError
+			}
+			statusClass := strconv.Itoa(status/100) + "xx"
+			m.requests.With(method, route, statusClass).Inc()
+			m.duration.With(method, route, statusClass).Observe(time.Since(started).Seconds())
+			m.responseSize.With(method, route, statusClass).Observe(float64(tw.BytesWritten()))
+			if p != nil {
+				// Let RecoveryMiddleware or net/http further out answer.
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(tw, req)
+	})
+}
+
+// ConnState satisfies the http.Server.ConnState / web.WebServerOptions
+// signature to track connections by state.
+func (m *HttpMetrics) ConnState(conn net.Conn, state http.ConnState) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if previous, ok := m.connStates[conn]; ok {
+		m.connections.With(previous.String()).Dec()
+	}
+	switch state {
+	case http.StateNew:
+		m.connectionsTotal.With().Inc()
+		fallthrough
+	case http.StateActive, http.StateIdle:
+		m.connStates[conn] = state
+		m.connections.With(state.String()).Inc()
+	default: // Closed or hijacked.
+		delete(m.connStates, conn)
+	}
+}
+
+// methodLabel bounds the method label's cardinality.
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE":
+		return method
+	}
+	return "OTHER"
+}
@@ -0,0 +1,83 @@
+package metrics_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gigawattio/web"
+	"github.com/gigawattio/web/metrics"
+	"github.com/gigawattio/web/route"
+)
+
+func TestHttpMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	httpMetrics := metrics.NewHttpMetrics(metrics.HttpOptions{Registry: registry, Namespace: "test"})
+	router := route.Activate([]route.RouteMiddlewareBundle{
+		{
+			RouteData: []route.RouteDatum{
+				{Reciever: "get", Path: "/users/:id", HandlerFunc: func(w http.ResponseWriter, req *http.Request) {
+					web.RespondWithText(w, http.StatusOK, "user "+web.RoutePattern(req))
+				}},
+				{Reciever: "post", Path: "/users", HandlerFunc: func(w http.ResponseWriter, req *http.Request) {
+					web.RespondWithJson(w, http.StatusBadRequest, web.JsonErrorFor(req, "nope"))
+				}},
+			},
+		},
+	})
+	server := web.NewWebServer(web.WebServerOptions{
+		Addr:      "127.0.0.1:0",
+		Handler:   httpMetrics.Middleware(router.Handler()),
+		ConnState: httpMetrics.ConnState,
+	})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	for _, id := range []string{"1", "2", "3"} {
+		resp, err := http.Get(fmt.Sprintf("%s/users/%s", server.BaseUrl(), id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if expected, actual := "user /users/:id", string(body); actual != expected {
+			t.Errorf("Expected body=%q but actual=%q", expected, actual)
+		}
+	}
+	resp, err := http.Post(server.BaseUrl()+"/users", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = http.Get(server.BaseUrl() + "/no/such/path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var buf bytes.Buffer
+	registry.WriteTo(&buf)
+	exposition := buf.String()
+	for _, expected := range []string{
+		`test_http_requests_total{method="GET",route="/users/:id",status="2xx"} 3`,
+		`test_http_requests_total{method="POST",route="/users",status="4xx"} 1`,
+		`test_http_requests_total{method="GET",route="unmatched",status="4xx"} 1`,
+		`test_http_request_duration_seconds_count{method="GET",route="/users/:id",status="2xx"} 3`,
+		`test_http_response_size_bytes_sum{method="GET",route="/users/:id",status="2xx"} 45`,
+		`test_http_requests_in_flight{method="GET"} 0`,
+		`test_http_connections{state="active"} 0`,
+		`# TYPE test_http_connections_total counter`,
+	} {
+		if !strings.Contains(exposition, expected) {
+			t.Errorf("Expected exposition to contain %q but actual:\n%s", expected, exposition)
+		}
+	}
+	if strings.Contains(exposition, "/users/1") {
+		t.Errorf("Expected raw paths to be absent from labels but actual:\n%s", exposition)
+	}
+}
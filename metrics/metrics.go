@@ -0,0 +1,382 @@
+// Package metrics provides dependency-free counters, gauges and histograms
+// which are exposed in the Prometheus text exposition format, along with
+// HTTP middleware for instrumenting web services.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the Prometheus text exposition format content type.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	// DefaultLatencyBuckets are histogram upper bounds in seconds.
+	DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	// DefaultSizeBuckets are histogram upper bounds in bytes.
+	DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+)
+
+// Registry holds a set of metrics and renders them for scraping.
+type Registry struct {
+	metrics []metric
+	names   map[string]struct{}
+	lock    sync.Mutex
+}
+
+// DefaultRegistry is used by the package level constructors.
+var DefaultRegistry = NewRegistry()
+
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	r := &Registry{
+		names: map[string]struct{}{},
+	}
+	return r
+}
+
+func (r *Registry) register(m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.names[m.name()]; ok {
+		panic(fmt.Sprintf("metrics: duplicate metric name %q", m.name()))
+	}
+	r.names[m.name()] = struct{}{}
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo renders every metric in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.lock.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.lock.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the registry's metrics, typically mounted at /metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteTo(w)
+	})
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	value float64
+	lock  sync.Mutex
+}
+
+// Inc adds 1.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter by v, which must not be negative.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counters can't decrease")
+	}
+	c.lock.Lock()
+	c.value += v
+	c.lock.Unlock()
+}
+
+// Value returns the current count.
+func (c *Counter) Value() float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.value
+}
+
+// Gauge is a value which can go up and down.
+type Gauge struct {
+	value float64
+	lock  sync.Mutex
+}
+
+// Set replaces the value.
+func (g *Gauge) Set(v float64) {
+	g.lock.Lock()
+	g.value = v
+	g.lock.Unlock()
+}
+
+// Add adjusts the value by v.
+func (g *Gauge) Add(v float64) {
+	g.lock.Lock()
+	g.value += v
+	g.lock.Unlock()
+}
+
+// Inc adds 1.
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+// Dec subtracts 1.
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// Value returns the current value.
+func (g *Gauge) Value() float64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.value
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	upperBounds []float64
+	counts      []uint64
+	count       uint64
+	sum         float64
+	lock        sync.Mutex
+}
+
+// Observe records a single value.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	h.lock.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.lock.Unlock()
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.count
+}
+
+// vec holds the labelled series of one metric.
+type vec struct {
+	metricName string
+	help       string
+	kind       string
+	labelNames []string
+	series     map[string]*series
+	newValue   func() interface{}
+	lock       sync.Mutex
+}
+
+type series struct {
+	labelValues []string
+	value       interface{}
+}
+
+func newVec(metricName string, help string, kind string, labelNames []string, newValue func() interface{}) *vec {
+	v := &vec{
+		metricName: metricName,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		series:     map[string]*series{},
+		newValue:   newValue,
+	}
+	return v
+}
+
+func (v *vec) name() string {
+	return v.metricName
+}
+
+func (v *vec) with(labelValues []string) interface{} {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %v label values but got %v", v.metricName, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{
+			labelValues: append([]string(nil), labelValues...),
+			value:       v.newValue(),
+		}
+		v.series[key] = s
+	}
+	return s.value
+}
+
+func (v *vec) write(w *bufio.Writer) {
+	v.lock.Lock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	all := make([]*series, len(keys))
+	for i, key := range keys {
+		all[i] = v.series[key]
+	}
+	v.lock.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.metricName, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.metricName, v.kind)
+	for _, s := range all {
+		switch value := s.value.(type) {
+		case *Counter:
+			writeSample(w, v.metricName, v.labelNames, s.labelValues, "", "", value.Value())
+		case *Gauge:
+			writeSample(w, v.metricName, v.labelNames, s.labelValues, "", "", value.Value())
+		case *Histogram:
+			value.lock.Lock()
+			var cumulative uint64
+			for i, upperBound := range value.upperBounds {
+				cumulative += value.counts[i]
+				writeSample(w, v.metricName+"_bucket", v.labelNames, s.labelValues, "le", formatFloat(upperBound), float64(cumulative))
+			}
+			writeSample(w, v.metricName+"_bucket", v.labelNames, s.labelValues, "le", "+Inf", float64(value.count))
+			writeSample(w, v.metricName+"_sum", v.labelNames, s.labelValues, "", "", value.sum)
+			writeSample(w, v.metricName+"_count", v.labelNames, s.labelValues, "", "", float64(value.count))
+			value.lock.Unlock()
+		}
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labelNames []string, labelValues []string, extraName string, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 || len(extraName) > 0 {
+		w.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labelName, escapeLabelValue(labelValues[i]))
+		}
+		if len(extraName) > 0 {
+			if len(labelNames) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// CounterVec is a set of counters partitioned by label values.
+type CounterVec struct {
+	*vec
+}
+
+// NewCounterVec creates and registers a CounterVec.
+func (r *Registry) NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	cv := &CounterVec{newVec(name, help, "counter", labelNames, func() interface{} { return &Counter{} })}
+	r.register(cv)
+	return cv
+}
+
+// With returns the counter for the label values, creating it if necessary.
+func (cv *CounterVec) With(labelValues ...string) *Counter {
+	return cv.with(labelValues).(*Counter)
+}
+
+// GaugeVec is a set of gauges partitioned by label values.
+type GaugeVec struct {
+	*vec
+}
+
+// NewGaugeVec creates and registers a GaugeVec.
+func (r *Registry) NewGaugeVec(name string, help string, labelNames ...string) *GaugeVec {
+	gv := &GaugeVec{newVec(name, help, "gauge", labelNames, func() interface{} { return &Gauge{} })}
+	r.register(gv)
+	return gv
+}
+
+// With returns the gauge for the label values, creating it if necessary.
+func (gv *GaugeVec) With(labelValues ...string) *Gauge {
+	return gv.with(labelValues).(*Gauge)
+}
+
+// HistogramVec is a set of histograms partitioned by label values.
+type HistogramVec struct {
+	*vec
+}
+
+// NewHistogramVec creates and registers a HistogramVec.  buckets are the
+// upper bounds, in increasing order.
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	hv := &HistogramVec{newVec(name, help, "histogram", labelNames, func() interface{} {
+		h := &Histogram{
+			upperBounds: buckets,
+			counts:      make([]uint64, len(buckets)),
+		}
+		return h
+	})}
+	r.register(hv)
+	return hv
+}
+
+// With returns the histogram for the label values, creating it if necessary.
+func (hv *HistogramVec) With(labelValues ...string) *Histogram {
+	return hv.with(labelValues).(*Histogram)
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests.\nMultiline \\ help.", "path")
+	temperature := r.NewGaugeVec("temperature", "Current temperature.")
+	latency := r.NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1}, "op")
+	r.NewGaugeVec("unused", "No series yet.", "x")
+
+	requests.With("/b").Add(2)
+	requests.With(`/a"quoted"`).Inc()
+	temperature.With().Set(21.5)
+	temperature.With().Dec()
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		latency.With("read").Observe(v)
+	}
+
+	expected := `# HELP requests_total Requests.\nMultiline \\ help.
+# TYPE requests_total counter
+requests_total{path="/a\"quoted\""} 1
+requests_total{path="/b"} 2
+# HELP temperature Current temperature.
+# TYPE temperature gauge
+temperature 20.5
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="read",le="0.1"} 2
+latency_seconds_bucket{op="read",le="1"} 3
+latency_seconds_bucket{op="read",le="+Inf"} 4
+latency_seconds_sum{op="read"} 3.65
+latency_seconds_count{op="read"} 4
+# HELP unused No series yet.
+# TYPE unused gauge
+`
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if actual := buf.String(); actual != expected {
+		t.Errorf("Expected exposition:\n%s\nbut actual:\n%s", expected, actual)
+	}
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if expected, actual := ContentType, rec.Header().Get("Content-Type"); actual != expected {
+		t.Errorf("Expected Content-Type=%q but actual=%q", expected, actual)
+	}
+	if actual := rec.Body.String(); actual != expected {
+		t.Errorf("Expected handler to serve the exposition but actual:\n%s", actual)
+	}
+}
+
+func TestRegistryMisuse(t *testing.T) {
+	testCases := []struct {
+		name string
+		fn   func(r *Registry)
+	}{
+		{"duplicate name", func(r *Registry) { r.NewCounterVec("x", ""); r.NewGaugeVec("x", "") }},
+		{"wrong label count", func(r *Registry) { r.NewCounterVec("x", "", "a").With() }},
+		{"negative counter", func(r *Registry) { r.NewCounterVec("x", "").With().Add(-1) }},
+	}
+	for i, testCase := range testCases {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("[i=%v] Expected panic for %s", i, testCase.name)
+				}
+			}()
+			testCase.fn(NewRegistry())
+		}()
+	}
+}
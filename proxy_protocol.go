@@ -0,0 +1,237 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProxyHeaderTimeout bounds how long a trusted source has to send its
+// PROXY protocol header.
+const DefaultProxyHeaderTimeout = 5 * time.Second
+
+var (
+	NoTrustedSourcesError     = errors.New("at least one trusted source is required for PROXY protocol")
+	MissingProxyHeaderError   = errors.New("missing PROXY protocol header")
+	MalformedProxyHeaderError = errors.New("malformed PROXY protocol header")
+)
+
+// proxyV2Signature prefixes every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolOptions configures ProxyProtocolListener.
+type ProxyProtocolOptions struct {
+	TrustedSources []string      // CIDRs or IPs of load balancers permitted to send PROXY headers.
+	HeaderTimeout  time.Duration // maximum duration to wait for the header, DefaultProxyHeaderTimeout if 0.
+}
+
+// ProxyProtocolListener wraps a listener to consume HAProxy PROXY protocol
+// (v1 text or v2 binary) headers, so that conn.RemoteAddr(), and in turn
+// req.RemoteAddr, reflect the original client rather than the load balancer.
+//
+// Only connections from trusted sources are inspected, and they must send a
+// header; connections from anywhere else are passed through untouched.  The
+// header is read lazily by the connection's serving goroutine, so a slow
+// source can't stall Accept.
+type ProxyProtocolListener struct {
+	net.Listener
+	trusted       []*net.IPNet
+	headerTimeout time.Duration
+}
+
+// NewProxyProtocolListener wraps l with PROXY protocol support.
+func NewProxyProtocolListener(l net.Listener, options ProxyProtocolOptions) (*ProxyProtocolListener, error) {
+	if len(options.TrustedSources) == 0 {
+		return nil, NoTrustedSourcesError
+	}
+	trusted, err := parseCidrs(options.TrustedSources)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol trusted source: %s", err)
+	}
+	if options.HeaderTimeout <= 0 {
+		options.HeaderTimeout = DefaultProxyHeaderTimeout
+	}
+	pl := &ProxyProtocolListener{
+		Listener:      l,
+		trusted:       trusted,
+		headerTimeout: options.HeaderTimeout,
+	}
+	return pl, nil
+}
+
+// Accept waits for and returns the next connection.
+func (pl *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := pl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	pc := &proxyConn{
+		Conn:     conn,
+		listener: pl,
+	}
+	return pc, nil
+}
+
+// proxyConn parses the PROXY header on first use.
+type proxyConn struct {
+	net.Conn
+	listener *ProxyProtocolListener
+	once     sync.Once
+	reader   *bufio.Reader
+	remote   net.Addr
+	err      error
+
+	lock         sync.Mutex
+	readDeadline time.Time
+}
+
+func (pc *proxyConn) init() {
+	pc.once.Do(func() {
+		pc.reader = bufio.NewReader(pc.Conn)
+		pc.remote = pc.Conn.RemoteAddr()
+
+		host, _, err := net.SplitHostPort(pc.remote.String())
+		if err != nil || !cidrsContain(pc.listener.trusted, net.ParseIP(host)) {
+			return
+		}
+
+		pc.Conn.SetReadDeadline(time.Now().Add(pc.listener.headerTimeout))
+		remote, err := readProxyHeader(pc.reader)
+		// Restore whatever deadline the server has asked for in the meantime.
+		pc.lock.Lock()
+		pc.Conn.SetReadDeadline(pc.readDeadline)
+		pc.lock.Unlock()
+
+		if err != nil {
+			logger.Infof("web.ProxyProtocolListener: rejecting connection from %s: %s", pc.remote, err)
+			pc.err = err
+			return
+		}
+		if remote != nil {
+			pc.remote = remote
+		}
+	})
+}
+
+func (pc *proxyConn) Read(b []byte) (int, error) {
+	pc.init()
+	if pc.err != nil {
+		return 0, pc.err
+	}
+	return pc.reader.Read(b)
+}
+
+func (pc *proxyConn) RemoteAddr() net.Addr {
+	pc.init()
+	return pc.remote
+}
+
+func (pc *proxyConn) SetDeadline(t time.Time) error {
+	pc.lock.Lock()
+	pc.readDeadline = t
+	pc.lock.Unlock()
+	return pc.Conn.SetDeadline(t)
+}
+
+func (pc *proxyConn) SetReadDeadline(t time.Time) error {
+	pc.lock.Lock()
+	pc.readDeadline = t
+	pc.lock.Unlock()
+	return pc.Conn.SetReadDeadline(t)
+}
+
+// readProxyHeader consumes a v1 or v2 header from r and returns the source
+// address it carries, or nil for UNKNOWN / LOCAL headers which don't carry
+// one.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	peek, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		if err == io.EOF {
+			return nil, MissingProxyHeaderError
+		}
+		return nil, err
+	}
+	if bytes.Equal(peek, proxyV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(peek, []byte("PROXY ")) {
+		return readProxyHeaderV1(r)
+	}
+	return nil, MissingProxyHeaderError
+}
+
+// readProxyHeaderV1 parses e.g. "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	// The spec caps v1 headers at 107 bytes including the CRLF.
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, MalformedProxyHeaderError
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, MalformedProxyHeaderError
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, MalformedProxyHeaderError
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, MalformedProxyHeaderError
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	switch header[12] & 0xf {
+	case 0x0: // LOCAL, e.g. load balancer health checks.
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, MalformedProxyHeaderError
+	}
+	switch header[13] {
+	case 0x11: // TCP over IPv4.
+		if len(payload) < 12 {
+			return nil, MalformedProxyHeaderError
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x21: // TCP over IPv6.
+		if len(payload) < 36 {
+			return nil, MalformedProxyHeaderError
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	default:
+		// UDP, unix sockets and unspecified carry nothing useful for HTTP.
+		return nil, nil
+	}
+}
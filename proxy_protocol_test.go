@@ -0,0 +1,121 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyProtocolWebServer(t *testing.T) {
+	testCases := []struct {
+		trusted          []string
+		preamble         string
+		expectedRemote   string
+		expectedRejected bool
+	}{
+		{
+			trusted:        []string{"127.0.0.1"},
+			preamble:       "PROXY TCP4 192.0.2.1 198.51.100.1 56324 80\r\n",
+			expectedRemote: "192.0.2.1:56324",
+		},
+		{
+			trusted:        []string{"127.0.0.0/8"},
+			preamble:       "PROXY TCP6 2001:db8::1 2001:db8::2 4711 80\r\n",
+			expectedRemote: "[2001:db8::1]:4711",
+		},
+		{
+			trusted:        []string{"127.0.0.1"},
+			preamble:       string(proxyV2Header(0x21, 0x11, []byte{203, 0, 113, 9}, 1234)),
+			expectedRemote: "203.0.113.9:1234",
+		},
+		{
+			trusted:        []string{"127.0.0.1"},
+			preamble:       "PROXY UNKNOWN\r\n",
+			expectedRemote: "127.0.0.1",
+		},
+		{
+			// Trusted sources must send a header.
+			trusted:          []string{"127.0.0.1"},
+			expectedRejected: true,
+		},
+		{
+			// Untrusted sources are passed through untouched.
+			trusted:        []string{"10.0.0.1"},
+			expectedRemote: "127.0.0.1",
+		},
+	}
+	for i, testCase := range testCases {
+		options := WebServerOptions{
+			Addr: testAddr,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				RespondWithText(w, http.StatusOK, req.RemoteAddr)
+			}),
+			ProxyProtocol: &ProxyProtocolOptions{
+				TrustedSources: testCase.trusted,
+				HeaderTimeout:  time.Second,
+			},
+		}
+		server := NewWebServer(options)
+		if err := server.Start(); err != nil {
+			t.Fatalf("[i=%v] %s", i, err)
+		}
+		conn, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			t.Fatalf("[i=%v] %s", i, err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte(testCase.preamble + "GET / HTTP/1.0\r\n\r\n")); err != nil {
+			t.Fatalf("[i=%v] %s", i, err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if testCase.expectedRejected {
+			// net/http answers failed reads with a bare 400 and hangs up.
+			if err == nil && resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("[i=%v] Expected connection to be rejected but got status-code=%v", i, resp.StatusCode)
+			}
+		} else if err != nil {
+			t.Errorf("[i=%v] Unexpected error reading response: %s", i, err)
+		} else {
+			body, _ := ioutil.ReadAll(resp.Body)
+			// Expectations without a port only pin down the host.
+			actual := string(body)
+			if expected := testCase.expectedRemote; actual != expected && !strings.HasPrefix(actual, expected+":") {
+				t.Errorf("[i=%v] Expected RemoteAddr=%q but actual=%q", i, expected, actual)
+			}
+		}
+		conn.Close()
+		if err := server.Stop(); err != nil {
+			t.Fatalf("[i=%v] %s", i, err)
+		}
+	}
+}
+
+func TestNewProxyProtocolListenerInvalid(t *testing.T) {
+	for i, options := range []ProxyProtocolOptions{{}, {TrustedSources: []string{"not-an-ip"}}} {
+		if _, err := NewProxyProtocolListener(nil, options); err == nil {
+			t.Errorf("[i=%v] Expected error for options=%+v but actual=<nil>", i, options)
+		}
+	}
+}
+
+// proxyV2Header builds a v2 header with an IPv4 source address.
+func proxyV2Header(verCmd byte, family byte, src []byte, srcPort uint16) []byte {
+	var buf bytes.Buffer
+	buf.Write(proxyV2Signature)
+	buf.WriteByte(verCmd)
+	buf.WriteByte(family)
+	payload := make([]byte, 12)
+	copy(payload[0:4], src)
+	copy(payload[4:8], []byte{127, 0, 0, 1})
+	binary.BigEndian.PutUint16(payload[8:10], srcPort)
+	binary.BigEndian.PutUint16(payload[10:12], 80)
+	binary.Write(&buf, binary.BigEndian, uint16(len(payload)))
+	buf.Write(payload)
+	return buf.Bytes()
+}
@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"container/list"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultMaxKeys is the number of keys a MemoryStore tracks before evicting
+// the least recently used.
+const DefaultMaxKeys = 100000
+
+var InvalidLimitError = errors.New("limit requests and period must both be positive")
+
+// MemoryStore is a process-local Store.  Keys are evicted once their state
+// would have fully replenished, as are the least recently used keys once more
+// than maxKeys are tracked.
+type MemoryStore struct {
+	maxKeys int
+	entries map[string]*list.Element
+	lru     *list.List // Front is most recently used.
+	lock    sync.Mutex
+}
+
+type memoryEntry struct {
+	key     string
+	expires time.Time
+
+	// Token bucket state.
+	tokens float64
+	last   time.Time
+
+	// Sliding window state.
+	windowStart time.Time
+	current     int
+	previous    int
+}
+
+// NewMemoryStore creates a MemoryStore tracking at most maxKeys keys.
+func NewMemoryStore(maxKeys int) *MemoryStore {
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+	store := &MemoryStore{
+		maxKeys: maxKeys,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+	return store
+}
+
+// Take satisfies the Store interface.
+func (store *MemoryStore) Take(key string, limit Limit, now time.Time) (Result, error) {
+	if err := limit.Validate(); err != nil {
+		return Result{}, err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.evictExpired(now)
+	var entry *memoryEntry
+	if element, ok := store.entries[key]; ok {
+		store.lru.MoveToFront(element)
+		entry = element.Value.(*memoryEntry)
+	} else {
+		for store.lru.Len() >= store.maxKeys {
+			store.remove(store.lru.Back())
+		}
+		entry = &memoryEntry{key: key}
+		store.entries[key] = store.lru.PushFront(entry)
+	}
+	if limit.Algorithm == SlidingWindow {
+		// The previous window still counts until the current one ends.
+		entry.expires = now.Add(2 * limit.Period)
+		return entry.takeSlidingWindow(limit, now), nil
+	}
+	result := entry.takeTokenBucket(limit, now)
+	entry.expires = now.Add(result.Reset) // Once full, the bucket is indistinguishable from a new one.
+	return result, nil
+}
+
+// Len returns the number of keys currently tracked.
+func (store *MemoryStore) Len() int {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return len(store.entries)
+}
+
+// evictExpired drops expired entries from the back of the LRU list.
+func (store *MemoryStore) evictExpired(now time.Time) {
+	for element := store.lru.Back(); element != nil; element = store.lru.Back() {
+		if now.Before(element.Value.(*memoryEntry).expires) {
+			break
+		}
+		store.remove(element)
+	}
+}
+
+func (store *MemoryStore) remove(element *list.Element) {
+	store.lru.Remove(element)
+	delete(store.entries, element.Value.(*memoryEntry).key)
+}
+
+func (entry *memoryEntry) takeTokenBucket(limit Limit, now time.Time) Result {
+	capacity := float64(limit.Burst)
+	if capacity <= 0 {
+		capacity = float64(limit.Requests)
+	}
+	rate := float64(limit.Requests) / float64(limit.Period) // Tokens per nanosecond.
+	if entry.last.IsZero() {
+		entry.tokens = capacity
+	} else {
+		entry.tokens = math.Min(capacity, entry.tokens+float64(now.Sub(entry.last))*rate)
+	}
+	entry.last = now
+
+	result := Result{Limit: int(capacity)}
+	if entry.tokens >= 1 {
+		entry.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((1 - entry.tokens) / rate))
+	}
+	result.Remaining = int(entry.tokens)
+	result.Reset = time.Duration(math.Ceil((capacity - entry.tokens) / rate))
+	return result
+}
+
+func (entry *memoryEntry) takeSlidingWindow(limit Limit, now time.Time) Result {
+	windowStart := now.Truncate(limit.Period)
+	switch elapsed := windowStart.Sub(entry.windowStart); {
+	case elapsed == limit.Period:
+		entry.previous, entry.current = entry.current, 0
+	case elapsed > limit.Period:
+		entry.previous, entry.current = 0, 0
+	}
+	entry.windowStart = windowStart
+
+	// Weight the previous window by how much of it still overlaps the rolling
+	// window ending now.
+	overlap := 1 - float64(now.Sub(windowStart))/float64(limit.Period)
+	estimate := float64(entry.previous)*overlap + float64(entry.current)
+
+	result := Result{
+		Limit: limit.Requests,
+		Reset: windowStart.Add(limit.Period).Sub(now),
+	}
+	if estimate+1 <= float64(limit.Requests) {
+		entry.current++
+		estimate++
+		result.Allowed = true
+	} else if entry.previous > 0 {
+		// Wait until enough of the previous window has slid out.
+		needed := (estimate + 1 - float64(limit.Requests)) / float64(entry.previous)
+		result.RetryAfter = time.Duration(needed * float64(limit.Period))
+		if result.RetryAfter > result.Reset {
+			result.RetryAfter = result.Reset
+		}
+	} else {
+		result.RetryAfter = result.Reset
+	}
+	result.Remaining = int(math.Max(0, float64(limit.Requests)-estimate))
+	return result
+}
@@ -0,0 +1,185 @@
+// Package ratelimit provides token-bucket and sliding-window rate limiting
+// middleware with pluggable request keying and storage.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gigawattio/web"
+	"github.com/gigawattio/web/auth"
+	"github.com/gigawattio/web/cookieauth"
+)
+
+// Algorithm selects how a Limit is enforced.
+type Algorithm int
+
+const (
+	TokenBucket   Algorithm = iota // Allows bursts up to Limit.Burst, refilling continuously.
+	SlidingWindow                  // Approximates a rolling window from the current and previous fixed windows.
+)
+
+// Limit describes an allowance of Requests per Period.
+type Limit struct {
+	Requests  int
+	Period    time.Duration
+	Burst     int // TokenBucket capacity, defaults to Requests.
+	Algorithm Algorithm
+}
+
+// Validate checks that Requests and Period are both positive.
+func (limit Limit) Validate() error {
+	if limit.Requests <= 0 || limit.Period <= 0 {
+		return InvalidLimitError
+	}
+	return nil
+}
+
+// Result is the outcome of consuming from a limit.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // Time until the allowance is fully replenished.
+	RetryAfter time.Duration // Time until the next request would be allowed, when !Allowed.
+}
+
+// Store tracks limiter state.  Implementations backed by shared storage (e.g.
+// redis) allow limits to be enforced across multiple processes.
+type Store interface {
+	// Take attempts to consume one request from the allowance for key.
+	Take(key string, limit Limit, now time.Time) (Result, error)
+}
+
+// KeyFunc identifies the client a request counts against.  Requests for which
+// ok is false are not limited.
+type KeyFunc func(req *http.Request) (key string, ok bool)
+
+// ByIp keys requests by the client IP address, as resolved by web.ClientIp
+// when a web.ClientIpResolver is in front of the limiter.
+func ByIp() KeyFunc {
+	return func(req *http.Request) (string, bool) {
+		host := web.ClientIp(req)
+		return "ip:" + host, len(host) > 0
+	}
+}
+
+// ByBasicAuthUser keys requests by the username verified by auth.BasicAuth,
+// which must run ahead of the limiter.  Unverified usernames are ignored, so
+// that clients can't evade the limit by rotating them or exhaust someone
+// else's; combine with FirstOf to fall back to e.g. ByIp.
+func ByBasicAuthUser() KeyFunc {
+	return func(req *http.Request) (string, bool) {
+		user, ok := auth.AuthenticatedUser(req)
+		if !ok {
+			return "", false
+		}
+		return "user:" + user, true
+	}
+}
+
+// ByCookieAuthUser keys requests by the userId from a cookieauth cookie.
+func ByCookieAuthUser(cookieAuth *cookieauth.CookieAuth) KeyFunc {
+	return func(req *http.Request) (string, bool) {
+		userId, err := cookieAuth.Read(req)
+		if err != nil || userId == 0 {
+			return "", false
+		}
+		return fmt.Sprintf("user:%v", userId), true
+	}
+}
+
+// ByHeader keys requests by the value of a header, e.g. an API key.
+func ByHeader(name string) KeyFunc {
+	return func(req *http.Request) (string, bool) {
+		value := req.Header.Get(name)
+		return "header:" + name + ":" + value, len(value) > 0
+	}
+}
+
+// FirstOf tries each KeyFunc in turn, e.g. to fall back from the
+// authenticated user to the client IP.
+func FirstOf(keyFuncs ...KeyFunc) KeyFunc {
+	return func(req *http.Request) (string, bool) {
+		for _, keyFunc := range keyFuncs {
+			if key, ok := keyFunc(req); ok {
+				return key, true
+			}
+		}
+		return "", false
+	}
+}
+
+// Options configures RateLimit.
+type Options struct {
+	Limit          Limit
+	Name           string       // Namespaces keys so separate limits (e.g. per route) can share a Store.
+	KeyFunc        KeyFunc      // Defaults to ByIp.
+	Store          Store        // Defaults to a new MemoryStore.
+	LimitedHandler http.Handler // Serves rejected requests, defaults to a 429 JsonError.
+}
+
+// RateLimit generates a middleware function which enforces a limit and
+// emits RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers, plus
+// Retry-After on rejection.
+//
+// Store errors are logged and the request is let through.  An invalid Limit
+// panics, as it would otherwise let every request through.
+func RateLimit(options Options) web.MiddlewareFunc {
+	if err := options.Limit.Validate(); err != nil {
+		panic(fmt.Sprintf("ratelimit: %s: %+v", err, options.Limit))
+	}
+	if options.KeyFunc == nil {
+		options.KeyFunc = ByIp()
+	}
+	if options.Store == nil {
+		options.Store = NewMemoryStore(DefaultMaxKeys)
+	}
+	if options.LimitedHandler == nil {
+		options.LimitedHandler = http.HandlerFunc(defaultLimitedHandler)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			key, ok := options.KeyFunc(req)
+			if !ok {
+				next.ServeHTTP(w, req)
+				return
+			}
+			result, err := options.Store.Take(options.Name+"|"+key, options.Limit, time.Now())
+			if err != nil {
+				web.RequestLogger(req).Errorf("ratelimit: store error for key=%s, allowing request: %s", key, err)
+				next.ServeHTTP(w, req)
+				return
+			}
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				options.LimitedHandler.ServeHTTP(w, req)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// Handler applies a limit to a single handler func, which is convenient for
+// per-route limits in a route.RouteDatum.
+func Handler(options Options, handlerFunc func(w http.ResponseWriter, req *http.Request)) func(w http.ResponseWriter, req *http.Request) {
+	return RateLimit(options)(http.HandlerFunc(handlerFunc)).ServeHTTP
+}
+
+func defaultLimitedHandler(w http.ResponseWriter, req *http.Request) {
+	web.RespondWithJson(w, http.StatusTooManyRequests, web.JsonErrorFor(req, "rate limit exceeded"))
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,192 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/web/auth"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var (
+		store = NewMemoryStore(0)
+		limit = Limit{Requests: 2, Period: time.Second, Burst: 3}
+		now   = time.Unix(1000, 0)
+	)
+	for i := 0; i < 3; i++ {
+		result, err := store.Take("k", limit, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed {
+			t.Fatalf("[i=%v] Expected burst request to be allowed", i)
+		}
+		if expected, actual := 2-i, result.Remaining; actual != expected {
+			t.Errorf("[i=%v] Expected remaining=%v but actual=%v", i, expected, actual)
+		}
+	}
+	result, _ := store.Take("k", limit, now)
+	if result.Allowed {
+		t.Fatal("Expected request beyond burst to be rejected")
+	}
+	if expected, actual := 500*time.Millisecond, result.RetryAfter; actual != expected {
+		t.Errorf("Expected RetryAfter=%s but actual=%s", expected, actual)
+	}
+	if result, _ = store.Take("k", limit, now.Add(500*time.Millisecond)); !result.Allowed {
+		t.Error("Expected request to be allowed once a token refilled")
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	var (
+		store = NewMemoryStore(0)
+		limit = Limit{Requests: 4, Period: time.Minute, Algorithm: SlidingWindow}
+		start = time.Unix(0, 0).Add(time.Hour)
+	)
+	for i := 0; i < 4; i++ {
+		if result, _ := store.Take("k", limit, start); !result.Allowed {
+			t.Fatalf("[i=%v] Expected request within limit to be allowed", i)
+		}
+	}
+	if result, _ := store.Take("k", limit, start); result.Allowed {
+		t.Fatal("Expected request over limit to be rejected")
+	}
+	// Halfway through the next window, half of the previous window's 4
+	// requests still count, leaving room for 2.
+	half := start.Add(90 * time.Second)
+	for i := 0; i < 2; i++ {
+		if result, _ := store.Take("k", limit, half); !result.Allowed {
+			t.Fatalf("[i=%v] Expected request to be allowed in the sliding window", i)
+		}
+	}
+	if result, _ := store.Take("k", limit, half); result.Allowed || result.RetryAfter <= 0 {
+		t.Fatalf("Expected rejection with a positive RetryAfter but actual=%+v", result)
+	}
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	var (
+		store = NewMemoryStore(2)
+		limit = Limit{Requests: 1, Period: time.Second}
+		now   = time.Unix(1000, 0)
+	)
+	store.Take("a", limit, now)
+	store.Take("b", limit, now)
+	store.Take("c", limit, now)
+	if expected, actual := 2, store.Len(); actual != expected {
+		t.Errorf("Expected len=%v after LRU eviction but actual=%v", expected, actual)
+	}
+	store.Take("d", limit, now.Add(2*time.Second))
+	if expected, actual := 1, store.Len(); actual != expected {
+		t.Errorf("Expected len=%v after expiry eviction but actual=%v", expected, actual)
+	}
+	if _, err := store.Take("e", Limit{}, now); err != InvalidLimitError {
+		t.Errorf("Expected err=%v but actual=%v", InvalidLimitError, err)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	handler := RateLimit(Options{
+		Limit:   Limit{Requests: 1, Period: time.Minute},
+		KeyFunc: FirstOf(ByBasicAuthUser(), ByHeader("X-Api-Key"), ByIp()),
+	})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	testCases := []struct {
+		remoteAddr string
+		apiKey     string
+		user       string
+		status     int
+	}{
+		{"10.0.0.1:1000", "", "", http.StatusNoContent},
+		{"10.0.0.1:1001", "", "", http.StatusTooManyRequests},
+		{"10.0.0.2:1000", "", "", http.StatusNoContent},
+		{"10.0.0.1:1000", "key-1", "", http.StatusNoContent},
+		{"10.0.0.2:1000", "key-1", "", http.StatusTooManyRequests},
+		// Unverified usernames fall back to the IP, so rotating them is futile.
+		{"10.0.0.3:1000", "", "jay", http.StatusNoContent},
+		{"10.0.0.3:1000", "", "bob", http.StatusTooManyRequests},
+	}
+	for i, testCase := range testCases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = testCase.remoteAddr
+		if len(testCase.apiKey) > 0 {
+			req.Header.Set("X-Api-Key", testCase.apiKey)
+		}
+		if len(testCase.user) > 0 {
+			req.SetBasicAuth(testCase.user, "pw")
+		}
+		handler.ServeHTTP(rec, req)
+		if rec.Code != testCase.status {
+			t.Errorf("[i=%v] Expected status-code=%v but actual=%v", i, testCase.status, rec.Code)
+		}
+		if expected, actual := "1", rec.Header().Get("RateLimit-Limit"); actual != expected {
+			t.Errorf("[i=%v] Expected RateLimit-Limit=%q but actual=%q", i, expected, actual)
+		}
+		if testCase.status == http.StatusTooManyRequests {
+			if actual := rec.Header().Get("Retry-After"); actual != "60" {
+				t.Errorf("[i=%v] Expected Retry-After=60 but actual=%q", i, actual)
+			}
+		}
+	}
+}
+
+func TestRateLimitByBasicAuthUser(t *testing.T) {
+	limited := RateLimit(Options{
+		Limit:   Limit{Requests: 1, Period: time.Minute},
+		KeyFunc: FirstOf(ByBasicAuthUser(), ByIp()),
+	})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	handler := auth.BasicAuth(auth.AuthOptions{
+		AuthFunc: func(username string, password string) bool { return password == "pw" },
+	})(limited)
+
+	testCases := []struct {
+		remoteAddr string
+		user       string
+		password   string
+		status     int
+	}{
+		{"10.0.0.1:1000", "jay", "pw", http.StatusNoContent},
+		{"10.0.0.2:1000", "jay", "pw", http.StatusTooManyRequests},
+		{"10.0.0.2:1000", "amy", "pw", http.StatusNoContent},
+		// Failed authentication never reaches the limiter, so can't use up
+		// jay's allowance.
+		{"10.0.0.3:1000", "amy", "wrong", http.StatusUnauthorized},
+		{"10.0.0.3:1000", "amy", "wrong", http.StatusUnauthorized},
+	}
+	for i, testCase := range testCases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = testCase.remoteAddr
+		req.SetBasicAuth(testCase.user, testCase.password)
+		handler.ServeHTTP(rec, req)
+		if rec.Code != testCase.status {
+			t.Errorf("[i=%v] Expected status-code=%v but actual=%v", i, testCase.status, rec.Code)
+		}
+	}
+}
+
+func TestRateLimitInvalidLimit(t *testing.T) {
+	testCases := []Limit{
+		{},
+		{Requests: 1},
+		{Period: time.Second},
+		{Requests: -1, Period: time.Second},
+	}
+	for i, limit := range testCases {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("[i=%v] Expected RateLimit to panic for invalid limit=%+v", i, limit)
+				}
+			}()
+			RateLimit(Options{Limit: limit})
+		}()
+	}
+}
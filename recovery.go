@@ -0,0 +1,70 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicReporter is invoked with every recovered panic, e.g. to forward it to
+// an error tracker.
+type PanicReporter func(req *http.Request, recovered interface{}, stack []byte)
+
+// RecoveryOptions configures RecoveryMiddleware.
+type RecoveryOptions struct {
+	Reporter    PanicReporter // Optional.
+	Development bool          // Include the panic value and stack trace in responses.
+}
+
+// RecoveryMiddleware generates a middleware function which recovers panics
+// from downstream handlers, logs them along with the stack and request
+// context, and responds with a 500 JsonError when nothing has been written
+// yet.
+//
+// http.ErrAbortHandler is re-panicked so that net/http can abort the response
+// as intended.
+func RecoveryMiddleware(options RecoveryOptions) MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			tw := NewTrackingResponseWriter(w)
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				stack := debug.Stack()
+				RequestLogger(req).With("method", req.Method).With("uri", req.RequestURI).Errorf("web.RecoveryMiddleware: recovered panic: %v\n%s", recovered, stack)
+				if options.Reporter != nil {
+					report(options.Reporter, req, recovered, stack)
+				}
+				if tw.WroteHeader() {
+					// Too late to change the status, the best we can do is
+					// abort the connection so that the client doesn't mistake
+					// the truncated response for a complete one.
+					panic(http.ErrAbortHandler)
+				}
+				body := JsonErrorFor(req, http.StatusText(http.StatusInternalServerError))
+				if options.Development {
+					body["panic"] = fmt.Sprint(recovered)
+					body["stack"] = string(stack)
+				}
+				RespondWithJson(tw, http.StatusInternalServerError, body)
+			}()
+			next.ServeHTTP(tw, req)
+		})
+	}
+}
+
+// report invokes the reporter while shielding the request from any panic it
+// raises itself.
+func report(reporter PanicReporter, req *http.Request, recovered interface{}, stack []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			RequestLogger(req).Errorf("web.RecoveryMiddleware: panic in reporter: %v", r)
+		}
+	}()
+	reporter(req, recovered, stack)
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	var reported interface{}
+	reporter := func(req *http.Request, recovered interface{}, stack []byte) {
+		reported = recovered
+		panic("reporters can fail too")
+	}
+	panicky := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic("kaboom")
+	})
+
+	for _, development := range []bool{false, true} {
+		reported = nil
+		handler := RequestIdMiddleware(RecoveryMiddleware(RecoveryOptions{Reporter: reporter, Development: development})(panicky))
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		handler.ServeHTTP(rec, req)
+
+		if expected, actual := http.StatusInternalServerError, rec.Code; actual != expected {
+			t.Errorf("[development=%v] Expected status-code=%v but actual=%v", development, expected, actual)
+		}
+		if reported != "kaboom" {
+			t.Errorf("[development=%v] Expected reporter to receive %q but actual=%v", development, "kaboom", reported)
+		}
+		body := map[string]string{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("[development=%v] %s", development, err)
+		}
+		if body["requestId"] != rec.Header().Get(RequestIdHeader) {
+			t.Errorf("[development=%v] Expected requestId=%q in body=%v", development, rec.Header().Get(RequestIdHeader), body)
+		}
+		if _, ok := body["stack"]; ok != development {
+			t.Errorf("[development=%v] Expected stack present=%v in body=%v", development, development, body)
+		}
+		if development && !strings.Contains(body["stack"], "TestRecoveryMiddleware") {
+			t.Errorf("Expected stack to mention the test function but stack=%s", body["stack"])
+		}
+	}
+}
+
+func TestRecoveryMiddlewareAfterWrite(t *testing.T) {
+	handler := RecoveryMiddleware(RecoveryOptions{})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		RespondWithText(w, http.StatusAccepted, "partial")
+		panic("late kaboom")
+	}))
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	func() {
+		// The connection must be aborted so the truncated response isn't
+		// mistaken for a successful one.
+		defer func() {
+			if r := recover(); r != http.ErrAbortHandler {
+				t.Errorf("Expected http.ErrAbortHandler panic after the header was written but recovered=%v", r)
+			}
+		}()
+		handler.ServeHTTP(rec, req)
+	}()
+	if expected, actual := http.StatusAccepted, rec.Code; actual != expected {
+		t.Errorf("Expected status-code=%v but actual=%v", expected, actual)
+	}
+	if expected, actual := "partial", rec.Body.String(); actual != expected {
+		t.Errorf("Expected body=%q but actual=%q", expected, actual)
+	}
+}
+
+func TestRecoveryMiddlewareAbortHandler(t *testing.T) {
+	handler := RecoveryMiddleware(RecoveryOptions{})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("Expected http.ErrAbortHandler to be re-panicked but recovered=%v", r)
+		}
+	}()
+	req, _ := http.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
@@ -0,0 +1,90 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"net/http"
+	"time"
+)
+
+// RequestIdHeader is the header used to accept and echo request ids.
+const RequestIdHeader = "X-Request-ID"
+
+// MaxRequestIdLength is the longest incoming request id which will be trusted;
+// longer or malformed ids are replaced with a freshly generated one.
+const MaxRequestIdLength = 128
+
+// RequestIdMiddleware accepts an incoming X-Request-ID header or generates a
+// new ULID, stores it in the request context and echoes it in the response.
+//
+// The id is then available through RequestId, and is included by
+// RequestLogger and JsonErrorFor.
+func RequestIdMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(RequestIdHeader)
+		if !validRequestId(id) {
+			id = NewUlid()
+		}
+		w.Header().Set(RequestIdHeader, id)
+		next.ServeHTTP(w, WithRequestId(req, id))
+	})
+}
+
+// WithRequestId returns a shallow copy of req carrying the specified id.
+func WithRequestId(req *http.Request, id string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), requestIdKey, id))
+}
+
+// RequestId returns the id assigned to the request by RequestIdMiddleware, or
+// an empty string.
+func RequestId(req *http.Request) string {
+	if req == nil {
+		return ""
+	}
+	id, _ := req.Context().Value(requestIdKey).(string)
+	return id
+}
+
+func validRequestId(id string) bool {
+	if len(id) == 0 || len(id) > MaxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewUlid generates a Universally Unique Lexicographically Sortable
+// Identifier (https://github.com/ulid/spec) from the current time and 80 bits
+// of crypto/rand entropy.
+func NewUlid() string {
+	var b [16]byte
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	binary.BigEndian.PutUint64(b[:8], ms<<16)
+	if _, err := rand.Read(b[6:]); err != nil {
+		logger.Errorf("web.NewUlid: error reading random bytes: %s", err)
+	}
+	return encodeUlid(b)
+}
+
+// encodeUlid renders 128 bits as 26 Crockford base32 characters, the first of
+// which only carries the top 3 bits.
+func encodeUlid(b [16]byte) string {
+	var (
+		hi  = binary.BigEndian.Uint64(b[:8])
+		lo  = binary.BigEndian.Uint64(b[8:])
+		out [26]byte
+	)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
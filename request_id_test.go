@@ -0,0 +1,96 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIdMiddleware(t *testing.T) {
+	var seen string
+	handler := RequestIdMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		seen = RequestId(req)
+		RespondWithJson(w, http.StatusTeapot, JsonErrorFor(req, "short and stout"))
+	}))
+
+	testCases := []struct {
+		incoming string
+		reused   bool
+	}{
+		{"", false},
+		{"abc-123", true},
+		{"has spaces", false},
+		{strings.Repeat("x", MaxRequestIdLength+1), false},
+	}
+	for i, testCase := range testCases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		if len(testCase.incoming) > 0 {
+			req.Header.Set(RequestIdHeader, testCase.incoming)
+		}
+		handler.ServeHTTP(rec, req)
+
+		echoed := rec.Header().Get(RequestIdHeader)
+		if echoed != seen {
+			t.Errorf("[i=%v] Expected echoed id=%q to match context id=%q", i, echoed, seen)
+		}
+		if testCase.reused && echoed != testCase.incoming {
+			t.Errorf("[i=%v] Expected incoming id=%q to be reused but actual=%q", i, testCase.incoming, echoed)
+		}
+		if !testCase.reused && len(echoed) != 26 {
+			t.Errorf("[i=%v] Expected a generated ULID but actual=%q", i, echoed)
+		}
+		body := map[string]string{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("[i=%v] %s", i, err)
+		}
+		if body["requestId"] != echoed {
+			t.Errorf("[i=%v] Expected JsonErrorFor requestId=%q but actual=%q", i, echoed, body["requestId"])
+		}
+	}
+}
+
+func TestJsonErrorForWithoutRequestId(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	j := JsonErrorFor(req, "oops")
+	if _, ok := j["requestId"]; ok {
+		t.Errorf("Expected no requestId key but found one in %v", j)
+	}
+}
+
+func TestJsonErrorf(t *testing.T) {
+	j := JsonErrorf("bad %s at %d", "input", 3)
+	if expected, actual := "bad input at 3", j["error"]; actual != expected {
+		t.Errorf("Expected error=%q but actual=%q", expected, actual)
+	}
+}
+
+func TestNewUlid(t *testing.T) {
+	var previous string
+	for i := 0; i < 100; i++ {
+		id := NewUlid()
+		if len(id) != 26 {
+			t.Fatalf("Expected ULID length=26 but actual=%v for %q", len(id), id)
+		}
+		if strings.Trim(id, crockfordAlphabet) != "" {
+			t.Fatalf("ULID %q contains non-Crockford characters", id)
+		}
+		if id == previous {
+			t.Fatalf("Generated duplicate ULID %q", id)
+		}
+		// The timestamp prefix must never go backwards.
+		if len(previous) > 0 && id[:10] < previous[:10] {
+			t.Fatalf("ULID timestamp went backwards: %q < %q", id, previous)
+		}
+		previous = id
+	}
+	var b [16]byte
+	for i := range b {
+		b[i] = 0xff
+	}
+	if expected, actual := "7ZZZZZZZZZZZZZZZZZZZZZZZZZ", encodeUlid(b); actual != expected {
+		t.Errorf("Expected max ULID=%s but actual=%s", expected, actual)
+	}
+}
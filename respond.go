@@ -4,13 +4,8 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
-
-	log "github.com/sirupsen/logrus"
 )
 
-// TODO(jet) Add some form of logging control or toggle to this package,
-// particularly for control over the RespondWith* functions.
-
 type Json map[string]interface{}
 
 // RespondWith tries to detect what kind of data is being sent and serializes it
@@ -125,7 +120,7 @@ func RespondWithText(w http.ResponseWriter, statusCode int, data interface{}) (i
 
 func interceptErrors(statusCode int, err error) (int, error) {
 	if err != nil {
-		log.Errorf("Intercepted low-level HTTP response error during transmission: %s", err)
+		logger.Errorf("Intercepted low-level HTTP response error during transmission: %s", err)
 	}
 	return statusCode, err
 }
@@ -0,0 +1,88 @@
+package web
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+var HijackNotSupportedError = errors.New("underlying ResponseWriter does not support hijacking")
+
+// TrackingResponseWriter wraps an http.ResponseWriter to record the response
+// status code and the number of body bytes written, for use by middleware
+// such as access logging.
+type TrackingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+// NewTrackingResponseWriter wraps w.
+func NewTrackingResponseWriter(w http.ResponseWriter) *TrackingResponseWriter {
+	tw := &TrackingResponseWriter{
+		ResponseWriter: w,
+	}
+	return tw
+}
+
+// WriteHeader records the status code and passes it through.
+func (tw *TrackingResponseWriter) WriteHeader(statusCode int) {
+	if !tw.wroteHeader {
+		tw.status = statusCode
+		tw.wroteHeader = true
+	}
+	tw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write counts the bytes written and passes them through.
+func (tw *TrackingResponseWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.status = http.StatusOK
+		tw.wroteHeader = true
+	}
+	n, err := tw.ResponseWriter.Write(b)
+	tw.bytes += int64(n)
+	return n, err
+}
+
+// Status returns the status code sent, or 0 if nothing has been written yet.
+func (tw *TrackingResponseWriter) Status() int {
+	return tw.status
+}
+
+// BytesWritten returns the number of body bytes written.
+func (tw *TrackingResponseWriter) BytesWritten() int64 {
+	return tw.bytes
+}
+
+// WroteHeader reports whether the response headers have been sent.
+func (tw *TrackingResponseWriter) WroteHeader() bool {
+	return tw.wroteHeader
+}
+
+// Flush satisfies http.Flusher when the underlying writer does.
+func (tw *TrackingResponseWriter) Flush() {
+	if flusher, ok := tw.ResponseWriter.(http.Flusher); ok {
+		if !tw.wroteHeader {
+			tw.status = http.StatusOK
+			tw.wroteHeader = true
+		}
+		flusher.Flush()
+	}
+}
+
+// Hijack satisfies http.Hijacker when the underlying writer does.
+func (tw *TrackingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := tw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, HijackNotSupportedError
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (tw *TrackingResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
@@ -0,0 +1,157 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var RotatingFileClosedError = errors.New("rotating file is closed")
+
+// rotateRetryInterval is how long writes carry on in the current file after a
+// failed rotation before rotating is attempted again.
+const rotateRetryInterval = time.Minute
+
+// RotatingFile is an io.WriteCloser which appends to a file and rotates it
+// once it would grow beyond MaxBytes.  Rotated files are renamed with numeric
+// suffixes, "<name>.1" being the most recent, and at most MaxBackups of them
+// are kept.
+type RotatingFile struct {
+	Filename   string
+	MaxBytes   int64
+	MaxBackups int
+	file       *os.File
+	size       int64
+	closed     bool
+	retryAt    time.Time // when to next attempt rotation after a failure.
+	lock       sync.Mutex
+}
+
+// NewRotatingFile opens (or creates) filename for appending.
+func NewRotatingFile(filename string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		Filename:   filename,
+		MaxBytes:   maxBytes,
+		MaxBackups: maxBackups,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+// Write satisfies io.Writer, rotating first if p would not fit.  When rotation
+// fails writing carries on in the current file, so that logging doesn't stop,
+// and rotation isn't attempted again for rotateRetryInterval.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	if rf.closed {
+		return 0, RotatingFileClosedError
+	}
+	if rf.file == nil {
+		// An earlier reopen failed; try again.
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	if rf.MaxBytes > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.MaxBytes && !time.Now().Before(rf.retryAt) {
+		if err := rf.rotate(); err != nil && rf.file == nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Rotate forces a rotation regardless of the current size.
+func (rf *RotatingFile) Rotate() error {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	if rf.closed {
+		return RotatingFileClosedError
+	}
+	if rf.file == nil {
+		return rf.open()
+	}
+	return rf.rotate()
+}
+
+// rotate closes the current file, shifts the backups and opens a fresh one.
+// On failure the original path is reopened for appending, so rf.file is only
+// left nil when even that fails.
+func (rf *RotatingFile) rotate() error {
+	err := rf.file.Close()
+	rf.file = nil
+	if err == nil {
+		err = rf.shift()
+	}
+	if err != nil {
+		rf.retryAt = time.Now().Add(rotateRetryInterval)
+		logger.Errorf("web.RotatingFile: error rotating %s, continuing to append to it: %s", rf.Filename, err)
+		if openErr := rf.open(); openErr != nil {
+			logger.Errorf("web.RotatingFile: error reopening %s: %s", rf.Filename, openErr)
+		}
+		return err
+	}
+	rf.retryAt = time.Time{}
+	return rf.open()
+}
+
+func (rf *RotatingFile) shift() error {
+	if rf.MaxBackups > 0 {
+		os.Remove(rf.backupName(rf.MaxBackups))
+		for i := rf.MaxBackups - 1; i >= 1; i-- {
+			if err := os.Rename(rf.backupName(i), rf.backupName(i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(rf.Filename, rf.backupName(1)); err != nil {
+			return err
+		}
+	} else if err := os.Truncate(rf.Filename, 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (rf *RotatingFile) backupName(n int) string {
+	return fmt.Sprintf("%s.%v", rf.Filename, n)
+}
+
+// Close closes the current file.
+func (rf *RotatingFile) Close() error {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	if rf.closed {
+		return RotatingFileClosedError
+	}
+	rf.closed = true
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
@@ -0,0 +1,422 @@
+package route
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gigawatt.io/errorlib"
+	"github.com/gigawattio/web"
+)
+
+// DefaultConfigWatchInterval is how often a ConfigWatcher checks the config
+// file for changes when no interval is specified.
+const DefaultConfigWatchInterval = 2 * time.Second
+
+var (
+	NoBundlesError   = errors.New("route config must contain at least one bundle")
+	NilRegistryError = errors.New("registry must not be nil")
+)
+
+// Config is the declarative form of a slice of RouteMiddlewareBundles, e.g.:
+//
+//	bundles:
+//	  - middlewares: [logger]
+//	    routes:
+//	      - method: get
+//	        path: /
+//	        handler: index
+//	      - method: get|post
+//	        path: /ping
+//	        static:
+//	          status: 200
+//	          headers: {Content-Type: text/plain}
+//	          body: pong
+type Config struct {
+	Bundles []BundleConfig `json:"bundles" yaml:"bundles"`
+
+	dir string // Directory relative static body files are resolved against.
+}
+
+// BundleConfig is the declarative form of a RouteMiddlewareBundle.
+type BundleConfig struct {
+	Middlewares []string      `json:"middlewares,omitempty" yaml:"middlewares,omitempty"` // Names of middlewares in the Registry.
+	Routes      []RouteConfig `json:"routes" yaml:"routes"`
+}
+
+// RouteConfig is the declarative form of a RouteDatum.  Exactly one of Handler
+// or Static must be set.
+type RouteConfig struct {
+	Method  string        `json:"method" yaml:"method"` // Same format as RouteDatum.Reciever, e.g. "get" or "post|put".
+	Path    string        `json:"path" yaml:"path"`
+	Handler string        `json:"handler,omitempty" yaml:"handler,omitempty"` // Name of a handler in the Registry.
+	Static  *StaticConfig `json:"static,omitempty" yaml:"static,omitempty"`
+}
+
+// StaticConfig describes a response served by web.StaticHandlerFunc.
+type StaticConfig struct {
+	Status   int               `json:"status,omitempty" yaml:"status,omitempty"` // Defaults to 200.
+	Headers  map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body     string            `json:"body,omitempty" yaml:"body,omitempty"`
+	BodyFile string            `json:"bodyFile,omitempty" yaml:"bodyFile,omitempty"` // Relative paths are resolved against the config file directory.
+}
+
+// Registry holds the named handlers and middlewares a Config may refer to.
+type Registry struct {
+	Handlers    map[string]http.HandlerFunc
+	Middlewares map[string]func(http.Handler) http.Handler
+}
+
+// LoadConfig reads and decodes a route config file.  The format is selected by
+// file extension: ".json" for JSON, otherwise YAML.
+func LoadConfig(filename string) (*Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	contentType := web.MimeYaml
+	if strings.ToLower(filepath.Ext(filename)) == ".json" {
+		contentType = web.MimeJson
+	}
+	config, err := DecodeConfig(bytes.NewReader(data), contentType)
+	if err != nil {
+		return nil, fmt.Errorf("route: loading config from %s: %s", filename, err)
+	}
+	config.dir = filepath.Dir(filename)
+	return config, nil
+}
+
+// DecodeConfig deserializes a route config of the specified content type.
+func DecodeConfig(src io.Reader, contentType string) (*Config, error) {
+	config := &Config{}
+	var err error
+	switch contentType {
+	case web.MimeJson:
+		err = web.DecodeJson(src, config)
+	case web.MimeYaml, web.MimeYaml2, web.MimeYaml3:
+		err = web.DecodeYaml(src, config)
+	default:
+		err = fmt.Errorf("unable to decode route config with content-type=%s", contentType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Validate checks that every route has a supported method, a path, and a
+// handler which can be resolved against the registry, and that no two routes
+// in a bundle conflict, e.g. "/users/:id" and "/users/new" for the same method.
+func (config *Config) Validate(registry *Registry) error {
+	if registry == nil {
+		return NilRegistryError
+	}
+	if len(config.Bundles) == 0 {
+		return NoBundlesError
+	}
+	for i, bundle := range config.Bundles {
+		for _, name := range bundle.Middlewares {
+			if _, ok := registry.Middlewares[name]; !ok {
+				return fmt.Errorf("route config: bundles[%v]: unknown middleware %q", i, name)
+			}
+		}
+		for j, rc := range bundle.Routes {
+			if err := rc.validate(registry); err != nil {
+				return fmt.Errorf("route config: bundles[%v].routes[%v]: %s", i, j, err)
+			}
+			for k, other := range bundle.Routes[:j] {
+				if method, ok := conflicting(other, rc); ok {
+					return fmt.Errorf("route config: bundles[%v].routes[%v]: %s %s conflicts with routes[%v] %s", i, j, method, rc.Path, k, other.Path)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// conflicting reports whether two routes share a method for which the router
+// would refuse to register both paths, and if so which method.
+func conflicting(a RouteConfig, b RouteConfig) (string, bool) {
+	if !conflictingPaths(a.Path, b.Path) {
+		return "", false
+	}
+	for _, am := range strings.Split(a.Method, "|") {
+		for _, bm := range strings.Split(b.Method, "|") {
+			if strings.EqualFold(am, bm) {
+				return strings.ToUpper(am), true
+			}
+		}
+	}
+	return "", false
+}
+
+// conflictingPaths mirrors the router's rules: a path may not be registered
+// twice, a ":param" segment must be the only child of its parent and keep the
+// same name across routes, and a "*catchAll" segment may have no siblings,
+// including the parent path itself.
+func conflictingPaths(a string, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		switch {
+		case strings.HasPrefix(as[i], "*") || strings.HasPrefix(bs[i], "*"):
+			return true
+		case len(as[i]) == 0 || len(bs[i]) == 0:
+			// A trailing slash ends one path where the other has a child.
+			if as[i] != bs[i] {
+				return false
+			}
+		case strings.HasPrefix(as[i], ":") || strings.HasPrefix(bs[i], ":"):
+			if as[i] != bs[i] {
+				return true
+			}
+		case as[i] != bs[i]:
+			return false
+		}
+	}
+	return len(as) == len(bs)
+}
+
+func (rc RouteConfig) validate(registry *Registry) error {
+	if len(rc.Method) == 0 {
+		return errors.New("method must not be empty")
+	}
+	for _, method := range strings.Split(rc.Method, "|") {
+		if !supportedMethod(method) {
+			return fmt.Errorf("unsupported method %q", method)
+		}
+	}
+	if !strings.HasPrefix(rc.Path, "/") {
+		return fmt.Errorf("path %q must begin with a slash", rc.Path)
+	}
+	switch {
+	case len(rc.Handler) > 0 && rc.Static != nil:
+		return errors.New("handler and static are mutually exclusive")
+	case len(rc.Handler) > 0:
+		if _, ok := registry.Handlers[rc.Handler]; !ok {
+			return fmt.Errorf("unknown handler %q", rc.Handler)
+		}
+	case rc.Static != nil:
+		if len(rc.Static.Body) > 0 && len(rc.Static.BodyFile) > 0 {
+			return errors.New("static body and bodyFile are mutually exclusive")
+		}
+	default:
+		return errors.New("one of handler or static is required")
+	}
+	return nil
+}
+
+// RouteMiddlewareBundles validates the config and converts it into bundles
+// ready to be passed to Activate.  Static body files are read during this call.
+func (config *Config) RouteMiddlewareBundles(registry *Registry) ([]RouteMiddlewareBundle, error) {
+	if err := config.Validate(registry); err != nil {
+		return nil, err
+	}
+	rmbs := make([]RouteMiddlewareBundle, 0, len(config.Bundles))
+	for i, bundle := range config.Bundles {
+		rmb := RouteMiddlewareBundle{
+			Middlewares: make([]func(http.Handler) http.Handler, 0, len(bundle.Middlewares)),
+			RouteData:   make([]RouteDatum, 0, len(bundle.Routes)),
+		}
+		for _, name := range bundle.Middlewares {
+			rmb.Middlewares = append(rmb.Middlewares, registry.Middlewares[name])
+		}
+		for j, rc := range bundle.Routes {
+			handlerFunc, err := config.handlerFor(rc, registry)
+			if err != nil {
+				return nil, fmt.Errorf("route config: bundles[%v].routes[%v]: %s", i, j, err)
+			}
+			rmb.RouteData = append(rmb.RouteData, RouteDatum{
+				Reciever:    rc.Method,
+				Path:        rc.Path,
+				HandlerFunc: handlerFunc,
+			})
+		}
+		rmbs = append(rmbs, rmb)
+	}
+	return rmbs, nil
+}
+
+func (config *Config) handlerFor(rc RouteConfig, registry *Registry) (http.HandlerFunc, error) {
+	if rc.Static == nil {
+		return registry.Handlers[rc.Handler], nil
+	}
+	content := []byte(rc.Static.Body)
+	if len(rc.Static.BodyFile) > 0 {
+		filename := rc.Static.BodyFile
+		if !filepath.IsAbs(filename) && len(config.dir) > 0 {
+			filename = filepath.Join(config.dir, filename)
+		}
+		var err error
+		if content, err = ioutil.ReadFile(filename); err != nil {
+			return nil, err
+		}
+	}
+	status := rc.Static.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return web.StaticHandlerFunc(content, status, rc.Static.Headers), nil
+}
+
+func supportedMethod(method string) bool {
+	switch strings.ToLower(method) {
+	case "get", "post", "put", "patch", "delete":
+		return true
+	}
+	return false
+}
+
+// ConfigWatcher is an http.Handler which serves the routes from a config file
+// and, once started, reloads them whenever the file changes.  When a reload
+// fails the previously loaded routes stay in service.
+type ConfigWatcher struct {
+	filename string
+	registry *Registry
+	interval time.Duration
+	handler  atomic.Value // http.Handler
+	routes   atomic.Value // []RouteInfo
+	modTime  time.Time
+	stopChan chan struct{}
+	lock     sync.Mutex
+}
+
+// NewConfigWatcher loads the config file and returns a ConfigWatcher serving
+// its routes.  An interval of 0 means DefaultConfigWatchInterval.
+func NewConfigWatcher(filename string, registry *Registry, interval time.Duration) (*ConfigWatcher, error) {
+	if interval <= 0 {
+		interval = DefaultConfigWatchInterval
+	}
+	cw := &ConfigWatcher{
+		filename: filename,
+		registry: registry,
+		interval: interval,
+	}
+	if err := cw.Reload(); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (cw *ConfigWatcher) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	cw.handler.Load().(http.Handler).ServeHTTP(w, req)
+}
+
+// Reload unconditionally re-reads the config file and swaps in the new routes.
+func (cw *ConfigWatcher) Reload() error {
+	info, err := os.Stat(cw.filename)
+	if err != nil {
+		return err
+	}
+	config, err := LoadConfig(cw.filename)
+	if err != nil {
+		return err
+	}
+	rmbs, err := config.RouteMiddlewareBundles(cw.registry)
+	if err != nil {
+		return err
+	}
+	handler, err := activateSafely(rmbs)
+	if err != nil {
+		return err
+	}
+	cw.handler.Store(handler)
+	cw.routes.Store(Describe(rmbs))
+	cw.lock.Lock()
+	cw.modTime = info.ModTime()
+	cw.lock.Unlock()
+	logger.Debugf("route: loaded config from %s", cw.filename)
+	return nil
+}
+
+// activateSafely turns a panic raised while building the router, e.g. over a
+// conflict Validate didn't anticipate, into an error so that a bad edit can't
+// take down the watcher goroutine.
+func activateSafely(rmbs []RouteMiddlewareBundle) (handler http.Handler, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("route: activating config: %v", r)
+		}
+	}()
+	return Activate(rmbs).Handler(), nil
+}
+
+// Routes lists the routes currently in service.
+func (cw *ConfigWatcher) Routes() []RouteInfo {
+	return cw.routes.Load().([]RouteInfo)
+}
+
+// Start begins polling the config file for changes.
+func (cw *ConfigWatcher) Start() error {
+	cw.lock.Lock()
+	defer cw.lock.Unlock()
+
+	if cw.stopChan != nil {
+		return errorlib.AlreadyRunningError
+	}
+	cw.stopChan = make(chan struct{})
+	go cw.watch(cw.stopChan)
+	return nil
+}
+
+// Stop terminates polling.
+func (cw *ConfigWatcher) Stop() error {
+	cw.lock.Lock()
+	defer cw.lock.Unlock()
+
+	if cw.stopChan == nil {
+		return errorlib.NotRunningError
+	}
+	close(cw.stopChan)
+	cw.stopChan = nil
+	return nil
+}
+
+func (cw *ConfigWatcher) watch(stopChan chan struct{}) {
+	ticker := time.NewTicker(cw.interval)
+	defer ticker.Stop()
+	var (
+		retry     bool   // The last reload failed, e.g. due to a partially written file.
+		lastError string // Each distinct failure is only logged once.
+	)
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(cw.filename)
+			if err != nil {
+				logger.Errorf("route: error checking config file %s: %s", cw.filename, err)
+				continue
+			}
+			cw.lock.Lock()
+			changed := !info.ModTime().Equal(cw.modTime)
+			cw.lock.Unlock()
+			if !changed && !retry {
+				continue
+			}
+			// Keep retrying after a failure rather than waiting for the mtime
+			// to move on, since the completed file may share the mtime of the
+			// partial one which failed.
+			if err := cw.Reload(); err != nil {
+				retry = true
+				if err.Error() != lastError {
+					logger.Errorf("route: error reloading config file %s, keeping previous routes: %s", cw.filename, err)
+					lastError = err.Error()
+				}
+				continue
+			}
+			retry = false
+			lastError = ""
+		}
+	}
+}
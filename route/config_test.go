@@ -0,0 +1,258 @@
+package route_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/web"
+	"github.com/gigawattio/web/route"
+)
+
+const testRouteConfig = `
+bundles:
+  - routes:
+      - method: get
+        path: /
+        handler: index
+      - method: get|post
+        path: /ping
+        static:
+          status: 202
+          headers: {Content-Type: text/plain}
+          body: pong
+      - method: get
+        path: /file
+        static:
+          bodyFile: body.txt
+`
+
+func testRegistry() *route.Registry {
+	registry := &route.Registry{
+		Handlers: map[string]http.HandlerFunc{
+			"index": func(w http.ResponseWriter, req *http.Request) { fmt.Fprint(w, "hello world") },
+		},
+	}
+	return registry
+}
+
+func serve(h http.Handler, method string, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestConfigBundles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "route-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "body.txt"), []byte("from a file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "routes.yaml")
+	if err := ioutil.WriteFile(filename, []byte(testRouteConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := route.LoadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rmbs, err := config.RouteMiddlewareBundles(testRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := route.Activate(rmbs).Handler()
+
+	testCases := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"GET", "/", http.StatusOK, "hello world"},
+		{"GET", "/ping", http.StatusAccepted, "pong"},
+		{"POST", "/ping", http.StatusAccepted, "pong"},
+		{"GET", "/file", http.StatusOK, "from a file"},
+	}
+	for i, testCase := range testCases {
+		rec := serve(h, testCase.method, testCase.path)
+		if rec.Code != testCase.status {
+			t.Errorf("[i=%v] Expected status-code=%v but actual=%v for %s %s", i, testCase.status, rec.Code, testCase.method, testCase.path)
+		}
+		if actual := rec.Body.String(); actual != testCase.body {
+			t.Errorf("[i=%v] Expected body=%q but actual=%q for %s %s", i, testCase.body, actual, testCase.method, testCase.path)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	testCases := []struct {
+		config string
+		valid  bool
+	}{
+		{`{"bundles":[{"routes":[{"method":"get","path":"/","handler":"index"}]}]}`, true},
+		{`{"bundles":[]}`, false},
+		{`{"bundles":[{"routes":[{"method":"get","path":"/","handler":"missing"}]}]}`, false},
+		{`{"bundles":[{"routes":[{"method":"trace","path":"/","handler":"index"}]}]}`, false},
+		{`{"bundles":[{"routes":[{"method":"get","path":"nope","handler":"index"}]}]}`, false},
+		{`{"bundles":[{"routes":[{"method":"get","path":"/"}]}]}`, false},
+		{`{"bundles":[{"routes":[{"method":"get","path":"/","handler":"index","static":{}}]}]}`, false},
+		{`{"bundles":[{"middlewares":["missing"],"routes":[{"method":"get","path":"/","handler":"index"}]}]}`, false},
+		{`{"bundles":[{"routes":[{"method":"get","path":"/a","handler":"index"},{"method":"get|post","path":"/a","handler":"index"}]}]}`, false},
+		{`{"bundles":[{"routes":[{"method":"get","path":"/a","handler":"index"},{"method":"post","path":"/a","handler":"index"}]}]}`, true},
+		{`{"bundles":[{"routes":[{"method":"get","path":"/users/:id","handler":"index"},{"method":"get","path":"/users/new","handler":"index"}]}]}`, false},
+		{`{"bundles":[{"routes":[{"method":"get","path":"/users/:id","handler":"index"},{"method":"get","path":"/users/:name/posts","handler":"index"}]}]}`, false},
+		{`{"bundles":[{"routes":[{"method":"get","path":"/users/:id","handler":"index"},{"method":"get","path":"/users/:id/posts","handler":"index"}]}]}`, true},
+		{`{"bundles":[{"routes":[{"method":"get","path":"/users","handler":"index"},{"method":"get","path":"/users/:id","handler":"index"}]}]}`, true},
+		{`{"bundles":[{"routes":[{"method":"get","path":"/","handler":"index"},{"method":"get","path":"/:id","handler":"index"}]}]}`, true},
+		{`{"bundles":[{"routes":[{"method":"get","path":"/src/","handler":"index"},{"method":"get","path":"/src/*path","handler":"index"}]}]}`, false},
+		{`{"bundles":[{"routes":[{"method":"get","path":"/a","handler":"index"}]},{"routes":[{"method":"get","path":"/a","handler":"index"}]}]}`, true},
+	}
+	for i, testCase := range testCases {
+		config, err := route.DecodeConfig(bytes.NewBufferString(testCase.config), web.MimeJson)
+		if err != nil {
+			t.Fatalf("[i=%v] %s", i, err)
+		}
+		if err := config.Validate(testRegistry()); (err == nil) != testCase.valid {
+			t.Errorf("[i=%v] Expected valid=%v but actual err=%v", i, testCase.valid, err)
+		}
+	}
+}
+
+func TestConfigWatcherReloadPanic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "route-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "routes.json")
+	if err := ioutil.WriteFile(filename, []byte(`{"bundles":[{"routes":[{"method":"get","path":"/","handler":"index"}]}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	registry := testRegistry()
+	registry.Middlewares = map[string]func(http.Handler) http.Handler{
+		"boom": func(http.Handler) http.Handler { panic("boom") },
+	}
+	cw, err := route.NewConfigWatcher(filename, registry, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte(`{"bundles":[{"middlewares":["boom"],"routes":[{"method":"get","path":"/","handler":"index"}]}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Reload(); err == nil {
+		t.Errorf("Expected reload to return an error when activation panics")
+	}
+	if expected, actual := "hello world", serve(cw, "GET", "/").Body.String(); actual != expected {
+		t.Errorf("Expected body=%q after failed reload but actual=%q", expected, actual)
+	}
+}
+
+// TestConfigWatcherSameModTime ensures a file which is completed within the
+// same mtime tick as a partial write which failed to load is still picked up.
+func TestConfigWatcherSameModTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "route-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "routes.json")
+	write := func(config string, modTime time.Time) {
+		if err := ioutil.WriteFile(filename, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filename, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"bundles":[{"routes":[{"method":"get","path":"/","static":{"body":"v1"}}]}]}`, time.Now().Add(-time.Hour))
+
+	cw, err := route.NewConfigWatcher(filename, testRegistry(), 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cw.Stop()
+
+	modTime := time.Now()
+	write(`{"bundles":[{"routes":[{"method":"get","path":"/","static":{"bo`, modTime)
+	time.Sleep(50 * time.Millisecond) // Let the watcher fail on the partial file.
+	write(`{"bundles":[{"routes":[{"method":"get","path":"/","static":{"body":"v2"}}]}]}`, modTime)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for serve(cw, "GET", "/").Body.String() != "v2" {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the completed config to be loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConfigWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "route-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "routes.json")
+	write := func(body string, modTime time.Time) {
+		config := fmt.Sprintf(`{"bundles":[{"routes":[{"method":"get","path":"/","static":{"body":%q}}]}]}`, body)
+		if err := ioutil.WriteFile(filename, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filename, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("v1", time.Now().Add(-time.Hour))
+
+	cw, err := route.NewConfigWatcher(filename, testRegistry(), 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := cw.Stop(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if expected, actual := "v1", serve(cw, "GET", "/").Body.String(); actual != expected {
+		t.Fatalf("Expected body=%q but actual=%q", expected, actual)
+	}
+	if expected, actual := []route.RouteInfo{{Method: "GET", Path: "/"}}, cw.Routes(); len(actual) != 1 || actual[0] != expected[0] {
+		t.Errorf("Expected routes=%+v but actual=%+v", expected, actual)
+	}
+
+	write("v2", time.Now())
+	deadline := time.Now().Add(2 * time.Second)
+	for serve(cw, "GET", "/").Body.String() != "v2" {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for config reload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A broken config must not replace the working routes.
+	if err := ioutil.WriteFile(filename, []byte(`{"bundles":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if expected, actual := "v2", serve(cw, "GET", "/").Body.String(); actual != expected {
+		t.Errorf("Expected body=%q after broken reload but actual=%q", expected, actual)
+	}
+}
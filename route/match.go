@@ -0,0 +1,191 @@
+package route
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Matcher is a request predicate used to select bundles or handlers.  When a
+// request matches, the matcher returns the request to continue with, which
+// may carry additional context values such as captured host params.
+type Matcher func(req *http.Request) (*http.Request, bool)
+
+type contextKey int
+
+const hostParamsKey contextKey = iota
+
+// WildcardHostParam is the HostParam name under which the labels matched by a
+// leading "*" are captured, e.g. "a.b" for "a.b.example.com".
+const WildcardHostParam = "*"
+
+// Host matches the request host (sans port) against a pattern.  Each label of
+// the pattern may be literal, or of the form "{name}" to match any single
+// label and capture it as a host param.  A leading "*" label matches one or
+// more labels, e.g. "*.example.com" matches "a.b.example.com", and captures
+// them as WildcardHostParam.
+//
+// Example:
+//
+//	route.Host("{tenant}.example.com")
+//
+// Captured values are available through HostParam.
+func Host(pattern string) Matcher {
+	patternLabels := strings.Split(normalizeHost(pattern), ".")
+	return func(req *http.Request) (*http.Request, bool) {
+		var (
+			labels   = strings.Split(normalizeHost(req.Host), ".")
+			expected = patternLabels
+			wildcard string
+		)
+		if expected[0] == "*" {
+			expected = expected[1:]
+			if len(labels) <= len(expected) {
+				return req, false
+			}
+			wildcard = strings.Join(labels[:len(labels)-len(expected)], ".")
+			labels = labels[len(labels)-len(expected):]
+		} else if len(labels) != len(expected) {
+			return req, false
+		}
+		return matchLabels(req, expected, labels, wildcard)
+	}
+}
+
+func matchLabels(req *http.Request, patternLabels []string, labels []string, wildcard string) (*http.Request, bool) {
+	var params map[string]string
+	if len(wildcard) > 0 {
+		params = map[string]string{WildcardHostParam: wildcard}
+	}
+	for i, p := range patternLabels {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[p[1:len(p)-1]] = labels[i]
+			continue
+		}
+		if p != labels[i] {
+			return req, false
+		}
+	}
+	if len(params) > 0 {
+		if existing, ok := req.Context().Value(hostParamsKey).(map[string]string); ok {
+			for k, v := range existing {
+				if _, ok := params[k]; !ok {
+					params[k] = v
+				}
+			}
+		}
+		req = req.WithContext(context.WithValue(req.Context(), hostParamsKey, params))
+	}
+	return req, true
+}
+
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// HostParam returns the value of a host label captured by a Host matcher,
+// e.g. the "tenant" portion of "{tenant}.example.com".
+func HostParam(name string, req *http.Request) string {
+	params, _ := req.Context().Value(hostParamsKey).(map[string]string)
+	return params[name]
+}
+
+// Header matches when the named request header equals value, or when value is
+// empty, when the header is present at all.
+func Header(name string, value string) Matcher {
+	return func(req *http.Request) (*http.Request, bool) {
+		values, ok := req.Header[http.CanonicalHeaderKey(name)]
+		if !ok {
+			return req, false
+		}
+		if len(value) == 0 {
+			return req, true
+		}
+		for _, v := range values {
+			if v == value {
+				return req, true
+			}
+		}
+		return req, false
+	}
+}
+
+// Query matches when the named query string parameter equals value, or when
+// value is empty, when the parameter is present at all.
+func Query(key string, value string) Matcher {
+	return func(req *http.Request) (*http.Request, bool) {
+		values, ok := req.URL.Query()[key]
+		if !ok {
+			return req, false
+		}
+		if len(value) == 0 {
+			return req, true
+		}
+		for _, v := range values {
+			if v == value {
+				return req, true
+			}
+		}
+		return req, false
+	}
+}
+
+// Predicate adapts a plain boolean function into a Matcher.
+func Predicate(fn func(req *http.Request) bool) Matcher {
+	return func(req *http.Request) (*http.Request, bool) {
+		return req, fn(req)
+	}
+}
+
+// match applies all matchers in order, threading the request through each.
+func match(req *http.Request, matchers []Matcher) (*http.Request, bool) {
+	for _, matcher := range matchers {
+		var ok bool
+		if req, ok = matcher(req); !ok {
+			return req, false
+		}
+	}
+	return req, true
+}
+
+// Case pairs a set of matchers with the handler to run when they all pass.
+type Case struct {
+	Matchers    []Matcher
+	HandlerFunc func(w http.ResponseWriter, req *http.Request)
+}
+
+// Switch produces a handler func for a single RouteDatum which runs the first
+// case whose matchers all pass.  A case without matchers always matches, so
+// it can be used as a default.  When nothing matches a 404 is sent.
+func Switch(cases ...Case) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		for _, c := range cases {
+			if matched, ok := match(req, c.Matchers); ok {
+				c.HandlerFunc(w, matched)
+				return
+			}
+		}
+		http.NotFound(w, req)
+	}
+}
+
+// guard produces middleware which only lets requests satisfying the matchers
+// into the wrapped handler, diverting the rest to *skip.
+func guard(matchers []Matcher, skip *http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if matched, ok := match(req, matchers); ok {
+				next.ServeHTTP(w, matched)
+				return
+			}
+			(*skip).ServeHTTP(w, req)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package route_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gigawattio/web/route"
+)
+
+func TestHostAndHeaderRouting(t *testing.T) {
+	tenant := func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "tenant=%s", route.HostParam("tenant", req))
+	}
+	subdomain := func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "org subdomain=%s", route.HostParam(route.WildcardHostParam, req))
+	}
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "no such host", http.StatusMisdirectedRequest)
+	})
+	h := route.ActivateWithFallback(
+		[]route.RouteMiddlewareBundle{
+			{
+				Host: "api.example.com",
+				RouteData: []route.RouteDatum{
+					{Reciever: "get", Path: "/", HandlerFunc: route.Switch(
+						route.Case{Matchers: []route.Matcher{route.Header("X-Beta", "")}, HandlerFunc: textHandler("api beta")},
+						route.Case{Matchers: []route.Matcher{route.Query("format", "csv")}, HandlerFunc: textHandler("api csv")},
+						route.Case{HandlerFunc: textHandler("api")},
+					)},
+				},
+			},
+			{
+				Host:      "{tenant}.example.com",
+				RouteData: []route.RouteDatum{{Reciever: "get", Path: "/", HandlerFunc: tenant}},
+			},
+			{
+				Host:      "*.example.org",
+				RouteData: []route.RouteDatum{{Reciever: "get", Path: "/", HandlerFunc: subdomain}},
+			},
+		},
+		fallback,
+	).Handler()
+
+	testCases := []struct {
+		host    string
+		path    string
+		headers map[string]string
+		status  int
+		body    string
+	}{
+		{"api.example.com", "/", nil, http.StatusOK, "api"},
+		{"API.example.com:8080", "/", nil, http.StatusOK, "api"},
+		{"api.example.com", "/", map[string]string{"X-Beta": "1"}, http.StatusOK, "api beta"},
+		{"api.example.com", "/?format=csv", nil, http.StatusOK, "api csv"},
+		{"acme.example.com", "/", nil, http.StatusOK, "tenant=acme"},
+		{"a.b.example.org", "/", nil, http.StatusOK, "org subdomain=a.b"},
+		{"www.example.org", "/", nil, http.StatusOK, "org subdomain=www"},
+		{"example.org", "/", nil, http.StatusMisdirectedRequest, "no such host\n"},
+		{"a.b.example.com", "/", nil, http.StatusMisdirectedRequest, "no such host\n"},
+		{"acme.example.com", "/missing", nil, http.StatusMisdirectedRequest, "no such host\n"},
+	}
+	for i, testCase := range testCases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", testCase.path, nil)
+		req.Host = testCase.host
+		for k, v := range testCase.headers {
+			req.Header.Set(k, v)
+		}
+		h.ServeHTTP(rec, req)
+		if rec.Code != testCase.status {
+			t.Errorf("[i=%v] Expected status-code=%v but actual=%v for host=%s", i, testCase.status, rec.Code, testCase.host)
+		}
+		if actual := rec.Body.String(); actual != testCase.body {
+			t.Errorf("[i=%v] Expected body=%q but actual=%q for host=%s", i, testCase.body, actual, testCase.host)
+		}
+	}
+}
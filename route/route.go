@@ -5,15 +5,23 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/gigawattio/web"
+	"github.com/gigawattio/web/logging"
 	"github.com/nbio/hitch"
-	log "github.com/sirupsen/logrus"
 )
 
+var logger = logging.For("route")
+
 // RouteMiddlewareBundle is the struct which represents a group of
 // middleware + route entries.
+//
+// When Host or Matchers are set the bundle only handles requests which satisfy
+// them; all other requests pass through to the next bundle.
 type RouteMiddlewareBundle struct {
 	Middlewares []func(http.Handler) http.Handler
 	RouteData   []RouteDatum
+	Host        string    // Optional host pattern, see Host.
+	Matchers    []Matcher // Optional request predicates, all of which must pass.
 }
 
 // RouteDatum encompasses a single route entry.
@@ -27,18 +35,65 @@ type HttpMethodReceiver func(path string, handler http.Handler, middleware ...fu
 
 // Activate prepares a hitch for a single RouteMiddlewareBundle.
 func (rmb *RouteMiddlewareBundle) Activate() *hitch.Hitch {
+	var notFound http.Handler = http.NotFoundHandler()
+	return rmb.activate(&notFound)
+}
+
+// activate prepares a hitch which diverts requests not satisfying the bundle's
+// matchers to *skip.
+func (rmb *RouteMiddlewareBundle) activate(skip *http.Handler) *hitch.Hitch {
 	h := hitch.New()
+	matchers := rmb.Matchers
+	if len(rmb.Host) > 0 {
+		matchers = append([]Matcher{Host(rmb.Host)}, matchers...)
+	}
+	if len(matchers) > 0 {
+		h.Use(guard(matchers, skip))
+	}
 	h.Use(rmb.Middlewares...)
 	for _, routeDatum := range rmb.RouteData {
+		handler := withPattern(routeDatum.Path, routeDatum.HandlerFunc)
 		for _, method := range strings.Split(routeDatum.Reciever, "|") {
 			receiverFunc := rmb.lookupReceiver(h, method)
-			receiverFunc(routeDatum.Path, http.HandlerFunc(routeDatum.HandlerFunc))
-			log.Debugf("route: registered method=%s path=%s", method, routeDatum.Path)
+			receiverFunc(routeDatum.Path, handler)
+			logger.Debugf("route: registered method=%s path=%s", method, routeDatum.Path)
 		}
 	}
 	return h
 }
 
+// RouteInfo describes a registered route, e.g. for display on an admin page.
+type RouteInfo struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Host   string `json:"host,omitempty"`
+}
+
+// Describe lists the routes of one or more bundles in registration order, one
+// entry per method.
+func Describe(rmbs []RouteMiddlewareBundle) []RouteInfo {
+	routes := []RouteInfo{}
+	for _, rmb := range rmbs {
+		for _, routeDatum := range rmb.RouteData {
+			for _, method := range strings.Split(routeDatum.Reciever, "|") {
+				routes = append(routes, RouteInfo{
+					Method: strings.ToUpper(method),
+					Path:   routeDatum.Path,
+					Host:   rmb.Host,
+				})
+			}
+		}
+	}
+	return routes
+}
+
+// withPattern records the route pattern for web.RoutePattern.
+func withPattern(pattern string, hf func(w http.ResponseWriter, req *http.Request)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hf(w, web.WithRoutePattern(req, pattern))
+	})
+}
+
 // lookupReceiver takes an HTTP method string and returns the corresponding
 // hitch.{method} function.
 func (rmb *RouteMiddlewareBundle) lookupReceiver(h *hitch.Hitch, method string) HttpMethodReceiver {
@@ -60,17 +115,37 @@ func (rmb *RouteMiddlewareBundle) lookupReceiver(h *hitch.Hitch, method string)
 
 // Activate hitches one or more RouteMiddlewareBundle structs together.
 func Activate(rmbs []RouteMiddlewareBundle) *hitch.Hitch {
+	return ActivateWithFallback(rmbs, nil)
+}
+
+// ActivateWithFallback hitches one or more RouteMiddlewareBundle structs
+// together, sending requests which no bundle handles (e.g. due to an
+// unmatched host) to fallback.  A nil fallback responds with 404.
+func ActivateWithFallback(rmbs []RouteMiddlewareBundle, fallback http.Handler) *hitch.Hitch {
 	var head *hitch.Hitch
-	var tail *hitch.Hitch // Used to auto-link hitches together.
+	var tail *hitch.Hitch      // Used to auto-link hitches together.
+	var tailSkip *http.Handler // Where the tail diverts unmatched requests.
+	last := fallback
+	if last == nil {
+		last = http.NotFoundHandler()
+	}
 	for _, rmb := range rmbs {
-		h := rmb.Activate()
+		skip := new(http.Handler)
+		*skip = last
+		h := rmb.activate(skip)
 		if head == nil {
 			head = h
 		}
 		if tail != nil {
-			tail.Next(h.Handler())
+			handler := h.Handler()
+			tail.Next(handler)
+			*tailSkip = handler
 		}
 		tail = h
+		tailSkip = skip
+	}
+	if tail != nil && fallback != nil {
+		tail.Next(fallback)
 	}
 	return head
 }
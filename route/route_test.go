@@ -3,6 +3,7 @@ package route_test
 import (
 	"fmt"
 	"net/http"
+	"reflect"
 	"sync/atomic"
 	"testing"
 
@@ -71,3 +72,30 @@ func TestMiddlewares(t *testing.T) {
 		}
 	}
 }
+
+func TestDescribe(t *testing.T) {
+	noop := func(w http.ResponseWriter, req *http.Request) {}
+	rmbs := []route.RouteMiddlewareBundle{
+		{
+			Host: "api.example.com",
+			RouteData: []route.RouteDatum{
+				{"get", "/v1/apps", noop},
+				{"post|put", "/v1/apps/:id", noop},
+			},
+		},
+		{
+			RouteData: []route.RouteDatum{
+				{"get", "/", noop},
+			},
+		},
+	}
+	expected := []route.RouteInfo{
+		{Method: "GET", Path: "/v1/apps", Host: "api.example.com"},
+		{Method: "POST", Path: "/v1/apps/:id", Host: "api.example.com"},
+		{Method: "PUT", Path: "/v1/apps/:id", Host: "api.example.com"},
+		{Method: "GET", Path: "/"},
+	}
+	if actual := route.Describe(rmbs); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected routes=%+v but actual=%+v", expected, actual)
+	}
+}
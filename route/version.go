@@ -0,0 +1,228 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gigawattio/web"
+)
+
+var NoVersionsError = errors.New("versioned routes must contain at least one version")
+
+// VersionSelector extracts the requested API version from a request, along
+// with the path to route on within that version.  An empty version means the
+// selector did not find a version in the request.
+type VersionSelector func(req *http.Request) (version string, path string)
+
+// PrefixVersionSelector selects the version from the first path segment, e.g.
+// "/v2/users" selects "v2" and routes on "/users".
+func PrefixVersionSelector() VersionSelector {
+	return func(req *http.Request) (string, string) {
+		path := req.URL.Path
+		if len(path) < 2 || path[0] != '/' || (path[1] != 'v' && path[1] != 'V') {
+			return "", path
+		}
+		rest := path[1:]
+		end := strings.Index(rest, "/")
+		if end == -1 {
+			end = len(rest)
+		}
+		segment := rest[:end]
+		for _, c := range segment[1:] {
+			if c < '0' || c > '9' {
+				return "", path
+			}
+		}
+		if len(segment) == 1 {
+			return "", path
+		}
+		stripped := rest[end:]
+		if len(stripped) == 0 {
+			stripped = "/"
+		}
+		return segment, stripped
+	}
+}
+
+// AcceptVersionSelector selects the version from a media-type parameter in the
+// Accept header, e.g. "application/json; version=2" when param is "version".
+func AcceptVersionSelector(param string) VersionSelector {
+	return func(req *http.Request) (string, string) {
+		for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+			_, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+			if err != nil {
+				continue
+			}
+			if version := params[param]; len(version) > 0 {
+				return version, req.URL.Path
+			}
+		}
+		return "", req.URL.Path
+	}
+}
+
+// HeaderVersionSelector selects the version from a custom request header, e.g.
+// "Api-Version: 2".
+func HeaderVersionSelector(name string) VersionSelector {
+	return func(req *http.Request) (string, string) {
+		return strings.TrimSpace(req.Header.Get(name)), req.URL.Path
+	}
+}
+
+// Version is a single API version and the routes it defines or overrides.
+type Version struct {
+	Name            string // e.g. "v2".  A leading "v" is optional when matching.
+	Bundles         []RouteMiddlewareBundle
+	Deprecated      bool
+	DeprecatedAt    time.Time // Optional; emitted in the Deprecation header when set.
+	Sunset          time.Time // Optional; emitted in the Sunset header when set.
+	DeprecationLink string    // Optional; emitted as a rel="deprecation" Link header.
+}
+
+// VersionedRoutes maps requests onto one of several API versions.
+//
+// Versions must be ordered from oldest to newest.  Any route (method + path)
+// which a version does not define falls back to the closest previous version
+// which does.
+type VersionedRoutes struct {
+	Versions  []Version
+	Selectors []VersionSelector // Tried in order, defaults to PrefixVersionSelector.
+	Default   string            // Version used when no selector matches, defaults to the newest.
+}
+
+type versionRouter struct {
+	selectors []VersionSelector
+	fallback  string
+	versions  map[string]*activeVersion
+}
+
+type activeVersion struct {
+	Version
+	handler http.Handler
+}
+
+// Activate validates the versions and prepares a handler which dispatches
+// each request to the selected version.
+func (vr VersionedRoutes) Activate() (http.Handler, error) {
+	if len(vr.Versions) == 0 {
+		return nil, NoVersionsError
+	}
+	router := &versionRouter{
+		selectors: vr.Selectors,
+		fallback:  normalizeVersion(vr.Default),
+		versions:  map[string]*activeVersion{},
+	}
+	if len(router.selectors) == 0 {
+		router.selectors = []VersionSelector{PrefixVersionSelector()}
+	}
+	for i, version := range vr.Versions {
+		name := normalizeVersion(version.Name)
+		if len(name) == 0 {
+			return nil, fmt.Errorf("route: versions[%v] has an empty name", i)
+		}
+		if _, ok := router.versions[name]; ok {
+			return nil, fmt.Errorf("route: duplicate version %q", version.Name)
+		}
+		var handler http.Handler = http.NotFoundHandler()
+		if rmbs := withFallbacks(vr.Versions[:i+1]); len(rmbs) > 0 {
+			handler = Activate(rmbs).Handler()
+		}
+		router.versions[name] = &activeVersion{
+			Version: version,
+			handler: handler,
+		}
+	}
+	if len(router.fallback) == 0 {
+		router.fallback = normalizeVersion(vr.Versions[len(vr.Versions)-1].Name)
+	} else if _, ok := router.versions[router.fallback]; !ok {
+		return nil, fmt.Errorf("route: default version %q does not exist", vr.Default)
+	}
+	return router, nil
+}
+
+// withFallbacks flattens the bundles of the newest version in versions
+// followed by those of its predecessors, omitting routes which a newer version
+// already overrides.
+func withFallbacks(versions []Version) []RouteMiddlewareBundle {
+	var (
+		rmbs       []RouteMiddlewareBundle
+		overridden = map[string]struct{}{}
+	)
+	for i := len(versions) - 1; i >= 0; i-- {
+		var defined []string
+		for _, rmb := range versions[i].Bundles {
+			filtered := RouteMiddlewareBundle{Middlewares: rmb.Middlewares}
+			for _, routeDatum := range rmb.RouteData {
+				var methods []string
+				for _, method := range strings.Split(routeDatum.Reciever, "|") {
+					key := strings.ToLower(method) + " " + routeDatum.Path
+					if _, ok := overridden[key]; ok {
+						continue
+					}
+					methods = append(methods, method)
+					defined = append(defined, key)
+				}
+				if len(methods) > 0 {
+					routeDatum.Reciever = strings.Join(methods, "|")
+					filtered.RouteData = append(filtered.RouteData, routeDatum)
+				}
+			}
+			if len(filtered.RouteData) > 0 {
+				rmbs = append(rmbs, filtered)
+			}
+		}
+		for _, key := range defined {
+			overridden[key] = struct{}{}
+		}
+	}
+	return rmbs
+}
+
+func (router *versionRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	version, path := "", req.URL.Path
+	for _, selector := range router.selectors {
+		if version, path = selector(req); len(version) > 0 {
+			break
+		}
+	}
+	if len(version) == 0 {
+		version, path = router.fallback, req.URL.Path
+	}
+	active, ok := router.versions[normalizeVersion(version)]
+	if !ok {
+		web.RespondWithJson(w, http.StatusNotFound, web.JsonErrorFor(req, fmt.Sprintf("unknown API version %q", version)))
+		return
+	}
+	if active.Deprecated {
+		if active.DeprecatedAt.IsZero() {
+			w.Header().Set("Deprecation", "true")
+		} else {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%v", active.DeprecatedAt.Unix()))
+		}
+		if !active.Sunset.IsZero() {
+			w.Header().Set("Sunset", active.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if len(active.DeprecationLink) > 0 {
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="deprecation"`, active.DeprecationLink))
+		}
+	}
+	if path != req.URL.Path {
+		rewritten := new(http.Request)
+		*rewritten = *req
+		u := *req.URL
+		u.Path = path
+		u.RawPath = ""
+		rewritten.URL = &u
+		req = rewritten
+	}
+	active.handler.ServeHTTP(w, req)
+}
+
+func normalizeVersion(version string) string {
+	version = strings.ToLower(strings.TrimSpace(version))
+	return strings.TrimPrefix(version, "v")
+}
@@ -0,0 +1,111 @@
+package route_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/web/route"
+)
+
+func textHandler(s string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) { fmt.Fprint(w, s) }
+}
+
+func TestVersionedRoutes(t *testing.T) {
+	sunset := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	vr := route.VersionedRoutes{
+		Versions: []route.Version{
+			{
+				Name: "v1",
+				Bundles: []route.RouteMiddlewareBundle{
+					{RouteData: []route.RouteDatum{
+						{"get", "/users", textHandler("v1 users")},
+						{"get|post", "/widgets", textHandler("v1 widgets")},
+					}},
+				},
+				Deprecated: true,
+				Sunset:     sunset,
+			},
+			{
+				Name: "v2",
+				Bundles: []route.RouteMiddlewareBundle{
+					{RouteData: []route.RouteDatum{
+						{"get", "/users", textHandler("v2 users")},
+						{"post", "/widgets", textHandler("v2 widgets")},
+					}},
+				},
+			},
+		},
+		Selectors: []route.VersionSelector{
+			route.PrefixVersionSelector(),
+			route.AcceptVersionSelector("version"),
+			route.HeaderVersionSelector("Api-Version"),
+		},
+	}
+	h, err := vr.Activate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		method     string
+		path       string
+		headers    map[string]string
+		status     int
+		body       string
+		deprecated bool
+	}{
+		{"GET", "/v1/users", nil, http.StatusOK, "v1 users", true},
+		{"GET", "/v2/users", nil, http.StatusOK, "v2 users", false},
+		{"GET", "/users", nil, http.StatusOK, "v2 users", false},
+		{"GET", "/v2/widgets", nil, http.StatusOK, "v1 widgets", false},
+		{"POST", "/v2/widgets", nil, http.StatusOK, "v2 widgets", false},
+		{"GET", "/users", map[string]string{"Accept": "application/json; version=1"}, http.StatusOK, "v1 users", true},
+		{"GET", "/users", map[string]string{"Api-Version": "1"}, http.StatusOK, "v1 users", true},
+		{"GET", "/v3/users", nil, http.StatusNotFound, `{"error":"unknown API version \"v3\""}`, false},
+	}
+	for i, testCase := range testCases {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(testCase.method, testCase.path, nil)
+		for k, v := range testCase.headers {
+			req.Header.Set(k, v)
+		}
+		h.ServeHTTP(rec, req)
+		if rec.Code != testCase.status {
+			t.Errorf("[i=%v] Expected status-code=%v but actual=%v", i, testCase.status, rec.Code)
+		}
+		if actual := rec.Body.String(); actual != testCase.body {
+			t.Errorf("[i=%v] Expected body=%q but actual=%q", i, testCase.body, actual)
+		}
+		if actual := rec.Header().Get("Deprecation") == "true"; actual != testCase.deprecated {
+			t.Errorf("[i=%v] Expected deprecated=%v but actual=%v", i, testCase.deprecated, actual)
+		}
+		if testCase.deprecated {
+			if expected, actual := sunset.Format(http.TimeFormat), rec.Header().Get("Sunset"); actual != expected {
+				t.Errorf("[i=%v] Expected Sunset=%q but actual=%q", i, expected, actual)
+			}
+		}
+	}
+}
+
+func TestVersionedRoutesInvalid(t *testing.T) {
+	if _, err := (route.VersionedRoutes{}).Activate(); err != route.NoVersionsError {
+		t.Errorf("Expected err=%v but actual=%v", route.NoVersionsError, err)
+	}
+	vr := route.VersionedRoutes{
+		Versions: []route.Version{{Name: "v1"}, {Name: "1"}},
+	}
+	if _, err := vr.Activate(); err == nil {
+		t.Error("Expected duplicate version error but err=nil")
+	}
+	vr = route.VersionedRoutes{
+		Versions: []route.Version{{Name: "v1"}},
+		Default:  "v9",
+	}
+	if _, err := vr.Activate(); err == nil {
+		t.Error("Expected unknown default version error but err=nil")
+	}
+}
@@ -0,0 +1,50 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// routePatternSlot carries the matched route pattern back out to middleware
+// which wrapped the router, since the router only sees the request after
+// they've run.
+type routePatternSlot struct {
+	pattern string
+	lock    sync.Mutex
+}
+
+// TrackRoutePattern returns a shallow copy of req with room for the route
+// pattern to be recorded by the router, so that outer middleware (e.g.
+// metrics and logging) can see it via RoutePattern once the handler returns.
+func TrackRoutePattern(req *http.Request) *http.Request {
+	if _, ok := req.Context().Value(routePatternKey).(*routePatternSlot); ok {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), routePatternKey, &routePatternSlot{}))
+}
+
+// WithRoutePattern records the pattern, e.g. "/users/:id", of the route
+// which matched req.  The route package does this for every route it
+// registers.
+func WithRoutePattern(req *http.Request, pattern string) *http.Request {
+	if slot, ok := req.Context().Value(routePatternKey).(*routePatternSlot); ok {
+		slot.lock.Lock()
+		slot.pattern = pattern
+		slot.lock.Unlock()
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), routePatternKey, &routePatternSlot{pattern: pattern}))
+}
+
+// RoutePattern returns the pattern of the route which matched req, or an
+// empty string when unknown.
+func RoutePattern(req *http.Request) string {
+	slot, ok := req.Context().Value(routePatternKey).(*routePatternSlot)
+	if !ok {
+		return ""
+	}
+	slot.lock.Lock()
+	defer slot.lock.Unlock()
+	return slot.pattern
+}
@@ -0,0 +1,106 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultReferrerPolicy is sent when SecurityHeadersOptions.ReferrerPolicy is
+// empty.
+const DefaultReferrerPolicy = "strict-origin-when-cross-origin"
+
+// SecurityHeadersOptions configures SecurityHeadersMiddleware.  For the string
+// options, "-" omits the header entirely.
+type SecurityHeadersOptions struct {
+	HstsMaxAge            time.Duration // Strict-Transport-Security max-age, omitted if 0.  Only sent over https.
+	HstsIncludeSubdomains bool
+	HstsPreload           bool
+	FrameOptions          string                 // X-Frame-Options, "DENY" if empty.
+	ReferrerPolicy        string                 // Referrer-Policy, DefaultReferrerPolicy if empty.
+	PermissionsPolicy     string                 // Permissions-Policy, e.g. "camera=(), geolocation=()".  Omitted if empty.
+	ContentSecurityPolicy *ContentSecurityPolicy // optional.
+	ReportOnly            bool                   // send the CSP as Content-Security-Policy-Report-Only.
+}
+
+// SecurityHeadersMiddleware generates a middleware function which sets the
+// usual browser hardening headers on every response.
+//
+// X-Content-Type-Options is always "nosniff".  When the CSP has no
+// frame-ancestors directive one is derived from FrameOptions, and when it uses
+// CspNonceSource a fresh nonce is generated for each request and made
+// available through CspNonce.
+func SecurityHeadersMiddleware(options SecurityHeadersOptions) MiddlewareFunc {
+	if len(options.FrameOptions) == 0 {
+		options.FrameOptions = "DENY"
+	}
+	if len(options.ReferrerPolicy) == 0 {
+		options.ReferrerPolicy = DefaultReferrerPolicy
+	}
+	var hsts string
+	if options.HstsMaxAge > 0 {
+		hsts = fmt.Sprintf("max-age=%d", int64(options.HstsMaxAge/time.Second))
+		if options.HstsIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if options.HstsPreload {
+			hsts += "; preload"
+		}
+	}
+	csp := options.ContentSecurityPolicy
+	if csp != nil && !csp.Has("frame-ancestors") {
+		// Copy so that the caller's policy is left untouched.
+		csp = &ContentSecurityPolicy{directives: append([]cspDirective(nil), csp.directives...)}
+		switch strings.ToUpper(options.FrameOptions) {
+		case "DENY":
+			csp.Add("frame-ancestors", "'none'")
+		case "SAMEORIGIN":
+			csp.Add("frame-ancestors", "'self'")
+		}
+	}
+	cspHeader := "Content-Security-Policy"
+	if options.ReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	var (
+		useNonce  = csp != nil && csp.UsesNonce()
+		staticCsp string
+	)
+	if csp != nil && !useNonce {
+		staticCsp = csp.String()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			h := w.Header()
+			h.Set("X-Content-Type-Options", "nosniff")
+			if len(hsts) > 0 && ClientScheme(req) == "https" {
+				h.Set("Strict-Transport-Security", hsts)
+			}
+			setUnlessOmitted(h, "X-Frame-Options", options.FrameOptions)
+			setUnlessOmitted(h, "Referrer-Policy", options.ReferrerPolicy)
+			setUnlessOmitted(h, "Permissions-Policy", options.PermissionsPolicy)
+			if useNonce {
+				nonce, err := NewCspNonce()
+				if err != nil {
+					// Serving without a nonce would break the page's scripts,
+					// and a predictable one would defeat the policy.
+					RequestLogger(req).Errorf("web.SecurityHeadersMiddleware: error generating CSP nonce: %s", err)
+					RespondWithJson(w, http.StatusInternalServerError, JsonErrorFor(req, http.StatusText(http.StatusInternalServerError)))
+					return
+				}
+				h.Set(cspHeader, csp.Render(nonce))
+				req = withCspNonce(req, nonce)
+			} else if len(staticCsp) > 0 {
+				h.Set(cspHeader, staticCsp)
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func setUnlessOmitted(h http.Header, name string, value string) {
+	if len(value) > 0 && value != "-" {
+		h.Set(name, value)
+	}
+}
@@ -0,0 +1,146 @@
+package web
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeadersMiddleware(t *testing.T) {
+	testCases := []struct {
+		options  SecurityHeadersOptions
+		tls      bool
+		expected map[string]string
+	}{
+		{
+			options: SecurityHeadersOptions{},
+			expected: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "DENY",
+				"Referrer-Policy":           DefaultReferrerPolicy,
+				"Permissions-Policy":        "",
+				"Strict-Transport-Security": "",
+				"Content-Security-Policy":   "",
+			},
+		},
+		{
+			// HSTS is only meaningful over https.
+			options: SecurityHeadersOptions{HstsMaxAge: 365 * 24 * time.Hour},
+			expected: map[string]string{
+				"Strict-Transport-Security": "",
+			},
+		},
+		{
+			options: SecurityHeadersOptions{
+				HstsMaxAge:            time.Hour,
+				HstsIncludeSubdomains: true,
+				HstsPreload:           true,
+				FrameOptions:          "-",
+				ReferrerPolicy:        "no-referrer",
+				PermissionsPolicy:     "camera=(), geolocation=()",
+			},
+			tls: true,
+			expected: map[string]string{
+				"Strict-Transport-Security": "max-age=3600; includeSubDomains; preload",
+				"X-Frame-Options":           "",
+				"Referrer-Policy":           "no-referrer",
+				"Permissions-Policy":        "camera=(), geolocation=()",
+			},
+		},
+		{
+			options: SecurityHeadersOptions{
+				FrameOptions:          "SAMEORIGIN",
+				ContentSecurityPolicy: NewContentSecurityPolicy().Add("default-src", "'self'"),
+			},
+			expected: map[string]string{
+				"X-Frame-Options":         "SAMEORIGIN",
+				"Content-Security-Policy": "default-src 'self'; frame-ancestors 'self'",
+			},
+		},
+		{
+			options: SecurityHeadersOptions{
+				ContentSecurityPolicy: NewContentSecurityPolicy().Add("default-src", "'none'").Add("frame-ancestors", "https://example.com").Add("report-uri", "/csp"),
+				ReportOnly:            true,
+			},
+			expected: map[string]string{
+				"Content-Security-Policy":             "",
+				"Content-Security-Policy-Report-Only": "default-src 'none'; frame-ancestors https://example.com; report-uri /csp",
+			},
+		},
+	}
+	for i, testCase := range testCases {
+		handler := SecurityHeadersMiddleware(testCase.options)(http.NotFoundHandler())
+		rec := httptest.NewRecorder()
+		req := httptestRequest()
+		if testCase.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		handler.ServeHTTP(rec, req)
+		for name, expected := range testCase.expected {
+			if actual := rec.Header().Get(name); actual != expected {
+				t.Errorf("[i=%v] Expected %v header=%q but actual=%q", i, name, expected, actual)
+			}
+		}
+	}
+}
+
+func TestSecurityHeadersMiddlewareNonce(t *testing.T) {
+	csp := NewContentSecurityPolicy().Add("script-src", "'self'", CspNonceSource)
+	var nonces []string
+	handler := SecurityHeadersMiddleware(SecurityHeadersOptions{ContentSecurityPolicy: csp})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		nonces = append(nonces, CspNonce(req))
+	}))
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptestRequest())
+		nonce := nonces[i]
+		if len(nonce) == 0 {
+			t.Fatalf("[i=%v] Expected nonce in request context", i)
+		}
+		if expected, actual := "script-src 'self' 'nonce-"+nonce+"'; frame-ancestors 'none'", rec.Header().Get("Content-Security-Policy"); actual != expected {
+			t.Errorf("[i=%v] Expected CSP=%q but actual=%q", i, expected, actual)
+		}
+	}
+	if nonces[0] == nonces[1] {
+		t.Errorf("Expected a fresh nonce per request but both were %q", nonces[0])
+	}
+	if strings.Contains(csp.String(), "frame-ancestors") {
+		t.Errorf("Expected caller's policy to be left untouched but actual=%q", csp.String())
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("no entropy")
+}
+
+func TestSecurityHeadersMiddlewareNonceFailure(t *testing.T) {
+	defer func(reader io.Reader) { cspRandReader = reader }(cspRandReader)
+	cspRandReader = failingReader{}
+
+	if nonce, err := NewCspNonce(); err == nil {
+		t.Errorf("Expected error from NewCspNonce but got nonce=%q", nonce)
+	}
+	called := false
+	csp := NewContentSecurityPolicy().Add("script-src", CspNonceSource)
+	handler := SecurityHeadersMiddleware(SecurityHeadersOptions{ContentSecurityPolicy: csp})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		called = true
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptestRequest())
+	if called {
+		t.Errorf("Expected handler not to be called without a nonce")
+	}
+	if expected, actual := http.StatusInternalServerError, rec.Code; actual != expected {
+		t.Errorf("Expected status-code=%v but actual=%v", expected, actual)
+	}
+	if actual := rec.Header().Get("Content-Security-Policy"); len(actual) > 0 {
+		t.Errorf("Expected no CSP header but actual=%q", actual)
+	}
+}
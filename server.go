@@ -1,36 +1,57 @@
 package web
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	golog "log"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"sync"
 	"time"
 
 	"gigawatt.io/errorlib"
+	"github.com/gigawattio/web/logging"
+	"github.com/gigawattio/web/upgrade"
 	"github.com/jaytaylor/stoppableListener"
-	log "github.com/sirupsen/logrus"
 )
 
 const MaxStopChecks = 10
 
+// DefaultDrainTimeout is how long Stop waits for in-flight requests when
+// WebServerOptions.DrainTimeout isn't set.
+const DefaultDrainTimeout = 30 * time.Second
+
 type WebServerOptions struct {
-	Addr           string        // TCP address to listen on, ":http" if empty.
+	Addr           string        // TCP address to listen on, ":http" if empty, "unix:/path/to.sock" or "systemd:[name]".
 	Handler        http.Handler  // handler to invoke, http.DefaultServeMux if nil.
 	ReadTimeout    time.Duration // maximum duration before timing out read of the request.
 	WriteTimeout   time.Duration // maximum duration before timing out write of the response.
 	MaxHeaderBytes int           // maximum size of request headers, net/http.DefaultMaxHeaderBytes if 0.
-	TLSConfig      *tls.Config   // optional TLS config, used by ListenAndServeTLS.
+	TLSConfig      *tls.Config   // optional TLS config; when set Start serves HTTPS, see also Tls.
 	ErrorLog       *golog.Logger
+	Concurrency    *ConcurrencyOptions            // optional cap on in-flight requests, see ConcurrencyLimiter.
+	ProxyProtocol  *ProxyProtocolOptions          // optional PROXY protocol support, see ProxyProtocolListener.
+	ConnState      func(net.Conn, http.ConnState) // optional connection state hook, e.g. metrics.HttpMetrics.ConnState.
+	Health         *HealthRegistry                // optional, marked as draining for the duration of Stop.
+	DrainDelay     time.Duration                  // how long Stop keeps serving after readiness fails, so load balancers can notice.
+	DrainTimeout   time.Duration                  // how long Stop waits for in-flight requests to finish, DefaultDrainTimeout if 0.
+	Logger         logging.Logger                 // optional logger for the server and, via RequestLogger, its requests.
+	UnixSocket     UnixSocketOptions              // socket file permissions when Addr is a "unix:" path.
+	Listener       net.Listener                   // optional pre-opened listener used instead of Addr; closed by Stop.
+	Tls            *TlsOptions                    // optional HTTPS with certificate files reloaded on change.
 }
 
 type WebServer struct {
-	Options  WebServerOptions
-	server   *http.Server
-	listener *stoppableListener.StoppableListener
-	lock     sync.RWMutex
+	Options    WebServerOptions
+	server     *http.Server
+	listener   stoppable
+	registered net.Listener // listener passed on by upgrade.Upgrade, if any.
+	certs      *CertificateStore
+	redirect   *WebServer
+	lock       sync.RWMutex
 }
 
 type StaticHttpHandler struct {
@@ -70,48 +91,161 @@ func (ws *WebServer) Start() error {
 	if ws.server != nil || ws.listener != nil {
 		return errorlib.AlreadyRunningError
 	}
-	rawListener, err := net.Listen("tcp", ws.Options.Addr)
+	tlsConfig, certs, err := ws.tlsConfig()
+	if err != nil {
+		return err
+	}
+	rawListener, err := listen(ws.Options)
 	if err != nil {
 		return err
 	}
-	listener, err := stoppableListener.New(rawListener)
+	listener, err := makeStoppable(rawListener)
 	if err != nil {
 		return err
 	}
+	var serveListener net.Listener = listener
+	if ws.Options.ProxyProtocol != nil {
+		if serveListener, err = NewProxyProtocolListener(listener, *ws.Options.ProxyProtocol); err != nil {
+			listener.StopSafely()
+			return err
+		}
+	}
+	if tlsConfig != nil {
+		// The PROXY header, if any, precedes the TLS handshake.
+		serveListener = tls.NewListener(serveListener, tlsConfig)
+	}
+	if ws.Options.Tls != nil && len(ws.Options.Tls.RedirectAddr) > 0 {
+		var port string
+		if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok {
+			port = strconv.Itoa(tcpAddr.Port)
+		}
+		redirect := NewWebServer(WebServerOptions{
+			Addr:     ws.Options.Tls.RedirectAddr,
+			Handler:  HttpsRedirectHandler(port),
+			ErrorLog: ws.Options.ErrorLog,
+			Logger:   ws.Options.Logger,
+		})
+		if err := redirect.Start(); err != nil {
+			listener.StopSafely()
+			return err
+		}
+		ws.redirect = redirect
+	}
+	if certs != nil {
+		certs.Start()
+		ws.certs = certs
+	}
 	ws.listener = listener
+	if ws.Options.Listener == nil {
+		// Make the listener available to a new process during an upgrade.
+		if err := upgrade.Register(ws.Options.Addr, rawListener); err == nil {
+			ws.registered = rawListener
+		}
+	}
+	handler := ws.Options.Handler
+	if handler == nil && (ws.Options.Concurrency != nil || ws.Options.Logger != nil) {
+		handler = http.DefaultServeMux
+	}
+	if ws.Options.Concurrency != nil {
+		handler = NewConcurrencyLimiter(*ws.Options.Concurrency).Middleware(handler)
+	}
+	if ws.Options.Logger != nil {
+		handler = LoggerMiddleware(ws.Options.Logger)(handler)
+	}
 	ws.server = &http.Server{
-		Handler:        ws.Options.Handler,
+		Handler:        handler,
 		ReadTimeout:    ws.Options.ReadTimeout,
 		WriteTimeout:   ws.Options.WriteTimeout,
 		MaxHeaderBytes: ws.Options.MaxHeaderBytes,
-		TLSConfig:      ws.Options.TLSConfig,
+		TLSConfig:      tlsConfig,
 		ErrorLog:       ws.Options.ErrorLog,
+		ConnState:      ws.Options.ConnState,
+	}
+	if ws.Options.Health != nil {
+		ws.Options.Health.SetDraining(false)
 	}
 	go func() {
-		if err := ws.server.Serve(ws.listener); err != nil && err != stoppableListener.StoppedError {
-			log.Infof("web.WebServer: error on ws with Options=%+v: %s", ws.Options, err)
+		if err := ws.server.Serve(serveListener); err != nil && err != stoppableListener.StoppedError && err != http.ErrServerClosed {
+			ws.logger().Infof("web.WebServer: error on ws with Options=%+v: %s", ws.Options, err)
 		}
 		// log.Info("Server done!")
 	}()
 	return nil
 }
 
-// Stop terminates the WebServer.
+// Stop terminates the WebServer.  New connections are refused straight away,
+// idle keep-alive connections are closed, and in-flight requests are given up
+// to Options.DrainTimeout to finish before their connections are closed.
+//
+// When Options.Health is set readiness starts failing first, and requests
+// continue to be served for Options.DrainDelay so that load balancers have a
+// chance to notice.
 func (ws *WebServer) Stop() error {
-	ws.lock.Lock()
-	defer ws.lock.Unlock()
+	if health := ws.Options.Health; health != nil && ws.Listener() != nil {
+		health.SetDraining(true)
+		if ws.Options.DrainDelay > 0 {
+			ws.logger().Infof("web.WebServer: draining for %s before stopping", ws.Options.DrainDelay)
+			time.Sleep(ws.Options.DrainDelay)
+		}
+	}
 
+	ws.lock.Lock()
 	if ws.server == nil || ws.listener == nil {
+		ws.lock.Unlock()
 		return errorlib.NotRunningError
 	}
+	if ws.registered != nil {
+		upgrade.Unregister(ws.registered)
+		ws.registered = nil
+	}
+	if ws.certs != nil {
+		ws.certs.Stop()
+		ws.certs = nil
+	}
+	if ws.redirect != nil {
+		if err := ws.redirect.Stop(); err != nil {
+			ws.logger().Errorf("web.WebServer: error stopping https redirect server: %s", err)
+		}
+		ws.redirect = nil
+	}
 	if err := ws.listener.StopSafely(); err != nil {
+		ws.lock.Unlock()
 		return err
 	}
+	server := ws.server
 	ws.server = nil
 	ws.listener = nil
+	ws.lock.Unlock()
+
+	ws.drain(server)
 	return nil
 }
 
+// drain waits for the server's in-flight requests, then forcibly closes any
+// connections still active once Options.DrainTimeout has passed.
+func (ws *WebServer) drain(server *http.Server) {
+	timeout := ws.Options.DrainTimeout
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// Errors from closing the already stopped listener are of no interest.
+	if server.Shutdown(ctx) == context.DeadlineExceeded {
+		ws.logger().Warnf("web.WebServer: requests still in flight after drain timeout=%s, closing their connections", timeout)
+		server.Close()
+	}
+}
+
+// logger provides the "web.server" subsystem logger, writing to
+// Options.Logger when set.
+func (ws *WebServer) logger() logging.Logger {
+	if ws.Options.Logger != nil {
+		return logging.Subsystem("web.server", ws.Options.Logger)
+	}
+	return logging.For("web.server")
+}
+
 // Addr exposes the listener address.
 func (ws *WebServer) Addr() net.Addr {
 	ws.lock.RLock()
@@ -124,18 +258,47 @@ func (ws *WebServer) Addr() net.Addr {
 	return addr
 }
 
-// BaseUrl provides a working URL base path to the web server instance.
+// RedirectAddr exposes the address of the HTTP to HTTPS redirect listener, when
+// Options.Tls.RedirectAddr is set.
+func (ws *WebServer) RedirectAddr() net.Addr {
+	ws.lock.RLock()
+	defer ws.lock.RUnlock()
+
+	if ws.redirect == nil {
+		return &net.IPAddr{}
+	}
+	return ws.redirect.Addr()
+}
+
+// Certificates provides the store serving Options.Tls.Certificates while
+// running, e.g. to trigger a Reload, or nil.
+func (ws *WebServer) Certificates() *CertificateStore {
+	ws.lock.RLock()
+	defer ws.lock.RUnlock()
+	return ws.certs
+}
+
+// BaseUrl provides a working URL base path to the web server instance, using
+// https when TLS is configured.  For Unix sockets this takes the form
+// "http+unix://" followed by the escaped socket path, as understood by e.g.
+// curl --unix-socket wrappers; requests must be dialed over the socket.
 func (ws *WebServer) BaseUrl() string {
-	url := fmt.Sprintf("http://%s", ws.Addr())
-	return url
+	addr := ws.Addr()
+	if addr.Network() == "unix" {
+		return "http+unix://" + url.PathEscape(addr.String())
+	}
+	if ws.Options.TLSConfig != nil || ws.Options.Tls != nil {
+		return fmt.Sprintf("https://%s", addr)
+	}
+	return fmt.Sprintf("http://%s", addr)
 }
 
 // StaticHandlerFunc generates a handler which always serves up the same thing
 // regardless of the request.
 func StaticHandlerFunc(content []byte, statusCode int, headers map[string]string) http.HandlerFunc {
-	log.Infof("Creating new static handler func with headers=%+v and content=%s", headers, string(content))
+	logger.Debugf("Creating new static handler func with headers=%+v and len(content)=%v", headers, len(content))
 	handlerFn := func(w http.ResponseWriter, req *http.Request) {
-		log.Infof("In the static handler where headers=%+v and content=%s", headers, string(content))
+		RequestLogger(req).Debugf("In the static handler where headers=%+v and len(content)=%v", headers, len(content))
 		for k, v := range headers {
 			w.Header().Set(k, v)
 		}
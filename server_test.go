@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"strings"
 	"testing"
+	"time"
 )
 
 const testAddr = "127.0.0.1:0"
@@ -99,3 +100,75 @@ func TestBaseUrl(t *testing.T) {
 		t.Errorf(`Expected BaseUrl="%s" but instead found "%s"`, expected, actual)
 	}
 }
+
+// TestStopDrain ensures Stop lets in-flight requests finish.
+func TestStopDrain(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+	server := NewWebServer(WebServerOptions{Addr: testAddr, Handler: handler})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		response, err := http.Get(server.BaseUrl())
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer response.Body.Close()
+		body, err := ioutil.ReadAll(response.Body)
+		results <- result{string(body), err}
+	}()
+	<-started
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- server.Stop() }()
+	select {
+	case err := <-stopped:
+		t.Fatalf("Expected Stop to wait for the in-flight request but it returned err=%v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	if r := <-results; r.err != nil || r.body != "done" {
+		t.Errorf(`Expected body="done" but actual=%q err=%v`, r.body, r.err)
+	}
+}
+
+func TestStopDrainTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	})
+	server := NewWebServer(WebServerOptions{Addr: testAddr, Handler: handler, DrainTimeout: 50 * time.Millisecond})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go http.Get(server.BaseUrl())
+	<-started
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- server.Stop() }()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Stop to give up on the in-flight request")
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"strings"
 
 	"github.com/gigawattio/oslib"
-	log "github.com/sirupsen/logrus"
 )
 
 // AssetProvider defines the function signature for a StaticFilesMiddleware
@@ -53,13 +52,13 @@ func DiskBasedAssetProvider(basePath string) AssetProvider {
 		filepath := basePath + "/" + name
 		exists, err := oslib.PathExists(filepath)
 		if err != nil {
-			log.Errorf("error checking if filepath=%s exists: %s", filepath, err)
+			logger.Errorf("error checking if filepath=%s exists: %s", filepath, err)
 			return nil, err
 		}
 		if exists {
 			data, err := ioutil.ReadFile(filepath)
 			if err != nil {
-				log.Errorf("error reading filepath=%s: %s", filepath, err)
+				logger.Errorf("error reading filepath=%s: %s", filepath, err)
 				return nil, err
 			}
 			return data, nil
@@ -0,0 +1,121 @@
+// Package systemd implements the parts of the systemd service protocol used by
+// this module: socket activation (sd_listen_fds) and readiness notification
+// (sd_notify), without depending on libsystemd.
+package systemd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gigawattio/web/logging"
+)
+
+var logger = logging.For("systemd")
+
+// ListenFdsStart is the first file descriptor passed by systemd.
+const ListenFdsStart = 3
+
+var (
+	NoListenersError      = errors.New("systemd: no sockets were passed via LISTEN_FDS")
+	ListenerNotFoundError = errors.New("systemd: no unclaimed socket with that name was passed")
+)
+
+// listenFdsStart is a variable so tests can pass descriptors without
+// clobbering those already open in the test process.
+var listenFdsStart = ListenFdsStart
+
+// Files returns the sockets passed by systemd, named according to
+// LISTEN_FDNAMES (or "unknown", systemd's default).  It returns nothing when
+// LISTEN_PID doesn't match this process, e.g. because the variables were
+// inherited from a parent.  With unsetEnv the variables are removed so that
+// child processes don't misinterpret them.
+func Files(unsetEnv bool) ([]*os.File, error) {
+	if unsetEnv {
+		defer func() {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+		}()
+	}
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	files := make([]*os.File, count)
+	for i := range files {
+		name := "unknown"
+		if i < len(names) && len(names[i]) > 0 {
+			name = names[i]
+		}
+		files[i] = os.NewFile(uintptr(listenFdsStart+i), name)
+	}
+	return files, nil
+}
+
+// ActivatedListener is a socket passed by systemd.
+type ActivatedListener struct {
+	Name string
+	net.Listener
+}
+
+// Listeners converts the sockets passed by systemd into listeners, closing the
+// original descriptors.  Descriptors which aren't stream sockets, e.g. UDP or
+// FIFOs, are skipped.
+func Listeners(unsetEnv bool) ([]ActivatedListener, error) {
+	files, err := Files(unsetEnv)
+	if err != nil {
+		return nil, err
+	}
+	listeners := make([]ActivatedListener, 0, len(files))
+	for _, f := range files {
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			continue
+		}
+		listeners = append(listeners, ActivatedListener{Name: f.Name(), Listener: l})
+	}
+	return listeners, nil
+}
+
+var (
+	activated     []ActivatedListener
+	activatedErr  error
+	activatedOnce sync.Once
+	activatedLock sync.Mutex
+)
+
+// Listener claims a socket passed by systemd, by its FileDescriptorName= in
+// the .socket unit.  An empty name claims the first unclaimed socket.  Each
+// socket can be claimed once; the environment is consumed on first use.
+func Listener(name string) (net.Listener, error) {
+	activatedOnce.Do(func() {
+		activated, activatedErr = Listeners(true)
+	})
+	if activatedErr != nil {
+		return nil, activatedErr
+	}
+
+	activatedLock.Lock()
+	defer activatedLock.Unlock()
+
+	if len(activated) == 0 {
+		return nil, NoListenersError
+	}
+	for i, l := range activated {
+		if len(name) == 0 || l.Name == name {
+			activated = append(activated[:i:i], activated[i+1:]...)
+			return l.Listener, nil
+		}
+	}
+	return nil, ListenerNotFoundError
+}
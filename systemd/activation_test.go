@@ -0,0 +1,133 @@
+//go:build linux
+// +build linux
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// passListeners arranges for listeners to appear as if passed by systemd,
+// using descriptors well above those the test process has open.
+func passListeners(t *testing.T, names string, listeners ...*net.TCPListener) func() {
+	const start = 200
+	for i, l := range listeners {
+		f, err := l.File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := syscall.Dup3(int(f.Fd()), start+i, 0); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	listenFdsStart = start
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", strconv.Itoa(len(listeners)))
+	os.Setenv("LISTEN_FDNAMES", names)
+	return func() {
+		listenFdsStart = ListenFdsStart
+		for i := range listeners {
+			syscall.Close(start + i)
+		}
+	}
+}
+
+func newTcpListener(t *testing.T) *net.TCPListener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l.(*net.TCPListener)
+}
+
+func TestListeners(t *testing.T) {
+	a, b := newTcpListener(t), newTcpListener(t)
+	defer a.Close()
+	defer b.Close()
+	defer passListeners(t, "http:", a, b)()
+
+	listeners, err := Listeners(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 2, len(listeners); actual != expected {
+		t.Fatalf("Expected %v listeners but actual=%v", expected, actual)
+	}
+	testCases := []struct {
+		name string
+		addr string
+	}{
+		{name: "http", addr: a.Addr().String()},
+		{name: "unknown", addr: b.Addr().String()},
+	}
+	for i, testCase := range testCases {
+		if actual := listeners[i].Name; actual != testCase.name {
+			t.Errorf("[i=%v] Expected name=%q but actual=%q", i, testCase.name, actual)
+		}
+		if actual := listeners[i].Addr().String(); actual != testCase.addr {
+			t.Errorf("[i=%v] Expected addr=%q but actual=%q", i, testCase.addr, actual)
+		}
+		listeners[i].Close()
+	}
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if value := os.Getenv(name); len(value) > 0 {
+			t.Errorf("Expected %s to be unset but actual=%q", name, value)
+		}
+	}
+}
+
+func TestListenersWrongPid(t *testing.T) {
+	a := newTcpListener(t)
+	defer a.Close()
+	defer passListeners(t, "", a)()
+	os.Setenv("LISTEN_PID", "1")
+	defer os.Unsetenv("LISTEN_PID")
+
+	listeners, err := Listeners(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 0 {
+		t.Errorf("Expected no listeners for another process's LISTEN_PID but actual=%v", listeners)
+	}
+}
+
+func TestListenerClaim(t *testing.T) {
+	a, b := newTcpListener(t), newTcpListener(t)
+	defer a.Close()
+	defer b.Close()
+	defer passListeners(t, "http:admin", a, b)()
+	activatedOnce = sync.Once{}
+	defer func() { activatedOnce = sync.Once{} }()
+
+	testCases := []struct {
+		name string
+		addr string
+		err  error
+	}{
+		{name: "admin", addr: b.Addr().String()},
+		{name: "admin", err: ListenerNotFoundError},
+		{name: "", addr: a.Addr().String()},
+		{name: "", err: NoListenersError},
+	}
+	for i, testCase := range testCases {
+		l, err := Listener(testCase.name)
+		if err != testCase.err {
+			t.Errorf("[i=%v] Expected err=%v but actual=%v", i, testCase.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if actual := l.Addr().String(); actual != testCase.addr {
+			t.Errorf("[i=%v] Expected addr=%q but actual=%q", i, testCase.addr, actual)
+		}
+		l.Close()
+	}
+}
@@ -0,0 +1,85 @@
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Well-known sd_notify states.
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+var InvalidWatchdogError = errors.New("systemd: invalid WATCHDOG_USEC")
+
+// Status formats a STATUS= state, shown by systemctl status.
+func Status(format string, args ...interface{}) string {
+	return "STATUS=" + fmt.Sprintf(format, args...)
+}
+
+// Notify sends states to the service manager over NOTIFY_SOCKET, e.g.
+// Notify(Ready, Status("serving on %s", addr)).  It reports false without error
+// when not running under systemd.
+func Notify(states ...string) (bool, error) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if len(socketPath) == 0 {
+		return false, nil
+	}
+	if strings.HasPrefix(socketPath, "@") {
+		// Abstract namespace socket.
+		socketPath = "\x00" + socketPath[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often the service manager expects WATCHDOG=1
+// keep-alives, or 0 when the watchdog isn't enabled for this process.
+func WatchdogInterval() (time.Duration, error) {
+	usecString := os.Getenv("WATCHDOG_USEC")
+	if len(usecString) == 0 {
+		return 0, nil
+	}
+	if pidString := os.Getenv("WATCHDOG_PID"); len(pidString) > 0 {
+		if pid, err := strconv.Atoi(pidString); err != nil || pid != os.Getpid() {
+			return 0, nil
+		}
+	}
+	usec, err := strconv.ParseInt(usecString, 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, InvalidWatchdogError
+	}
+	return time.Duration(usec) * time.Microsecond, nil
+}
+
+// KeepAlive sends WATCHDOG=1 at half the interval, as systemd recommends,
+// until stopChan is closed.
+func KeepAlive(interval time.Duration, stopChan <-chan struct{}) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			if _, err := Notify(Watchdog); err != nil {
+				logger.Errorf("systemd: error sending watchdog keep-alive: %s", err)
+			}
+		}
+	}
+}
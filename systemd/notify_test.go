@@ -0,0 +1,103 @@
+package systemd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeNotifySocket listens where NOTIFY_SOCKET points, as systemd would.
+func fakeNotifySocket(t *testing.T) (*net.UnixConn, func()) {
+	dir, err := ioutil.TempDir("", "systemd-notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	os.Setenv("NOTIFY_SOCKET", path)
+	return conn, func() {
+		os.Unsetenv("NOTIFY_SOCKET")
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func readDatagram(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Errorf("Expected sent=false err=<nil> without NOTIFY_SOCKET but actual sent=%v err=%v", sent, err)
+	}
+
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	if sent, err := Notify(Ready, Status("serving on %s", "127.0.0.1:8080")); !sent || err != nil {
+		t.Fatalf("Expected sent=true err=<nil> but actual sent=%v err=%v", sent, err)
+	}
+	if expected, actual := "READY=1\nSTATUS=serving on 127.0.0.1:8080", readDatagram(t, conn); actual != expected {
+		t.Errorf("Expected datagram=%q but actual=%q", expected, actual)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	pid := strconv.Itoa(os.Getpid())
+	testCases := []struct {
+		usec     string
+		pid      string
+		expected time.Duration
+		err      error
+	}{
+		{expected: 0},
+		{usec: "30000000", expected: 30 * time.Second},
+		{usec: "500000", pid: pid, expected: 500 * time.Millisecond},
+		{usec: "500000", pid: "1", expected: 0},
+		{usec: "soon", err: InvalidWatchdogError},
+		{usec: "-5", err: InvalidWatchdogError},
+	}
+	for i, testCase := range testCases {
+		os.Setenv("WATCHDOG_USEC", testCase.usec)
+		os.Setenv("WATCHDOG_PID", testCase.pid)
+		interval, err := WatchdogInterval()
+		if err != testCase.err {
+			t.Errorf("[i=%v] Expected err=%v but actual=%v", i, testCase.err, err)
+		}
+		if interval != testCase.expected {
+			t.Errorf("[i=%v] Expected interval=%v but actual=%v", i, testCase.expected, interval)
+		}
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	conn, cleanup := fakeNotifySocket(t)
+	defer cleanup()
+
+	stopChan := make(chan struct{})
+	go KeepAlive(20*time.Millisecond, stopChan)
+	for i := 0; i < 2; i++ {
+		if expected, actual := Watchdog, readDatagram(t, conn); actual != expected {
+			t.Errorf("[i=%v] Expected datagram=%q but actual=%q", i, expected, actual)
+		}
+	}
+	close(stopChan)
+}
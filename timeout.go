@@ -0,0 +1,130 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is used when TimeoutOptions.Timeout isn't positive.
+const DefaultTimeout = 30 * time.Second
+
+// TimeoutOptions configures TimeoutMiddleware.
+type TimeoutOptions struct {
+	Timeout    time.Duration // Defaults to DefaultTimeout.
+	StatusCode int           // Sent on timeout, defaults to 503.  504 is also common.
+	Message    string        // JsonError detail sent on timeout, defaults to the status text.
+}
+
+// TimeoutMiddleware generates a middleware function which attaches a deadline
+// to the request context and, if the handler hasn't finished by then, answers
+// with a JsonError.  Apply it in separate route bundles to give routes
+// different timeouts.
+//
+// Handler output is buffered until the handler returns so that a timeout
+// response can still be sent; writes made after the deadline fail with
+// http.ErrHandlerTimeout and are discarded.  Handlers should watch
+// req.Context().Done() to stop work early.
+func TimeoutMiddleware(options TimeoutOptions) MiddlewareFunc {
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.StatusCode == 0 {
+		options.StatusCode = http.StatusServiceUnavailable
+	}
+	if len(options.Message) == 0 {
+		options.Message = http.StatusText(options.StatusCode)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ctx, cancel := context.WithTimeout(req.Context(), options.Timeout)
+			defer cancel()
+			req = req.WithContext(ctx)
+
+			var (
+				tw = &timeoutWriter{
+					header: http.Header{},
+				}
+				done     = make(chan struct{})
+				panicked = make(chan interface{}, 1)
+			)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				next.ServeHTTP(tw, req)
+				close(done)
+			}()
+			select {
+			case p := <-panicked:
+				// Re-panic on the serving goroutine so that RecoveryMiddleware
+				// or net/http can deal with it.
+				panic(p)
+
+			case <-done:
+				tw.lock.Lock()
+				defer tw.lock.Unlock()
+				dst := w.Header()
+				for k, v := range tw.header {
+					dst[k] = v
+				}
+				if tw.status == 0 {
+					tw.status = http.StatusOK
+				}
+				w.WriteHeader(tw.status)
+				w.Write(tw.buf.Bytes())
+
+			case <-ctx.Done():
+				tw.lock.Lock()
+				defer tw.lock.Unlock()
+				tw.timedOut = true
+				if ctx.Err() == context.DeadlineExceeded {
+					RequestLogger(req).Infof("web.TimeoutMiddleware: %s %s exceeded timeout=%s", req.Method, req.RequestURI, options.Timeout)
+					RespondWithJson(w, options.StatusCode, JsonErrorFor(req, options.Message))
+				}
+				// Otherwise the client went away, so there is no one to answer.
+			}
+		})
+	}
+}
+
+// timeoutWriter buffers a handler's response until it either completes or
+// times out.
+type timeoutWriter struct {
+	header   http.Header
+	buf      bytes.Buffer
+	status   int
+	timedOut bool
+	lock     sync.Mutex
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.buf.Write(b)
+}
+
+func (tw *timeoutWriter) WriteHeader(statusCode int) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	if tw.timedOut || tw.status != 0 {
+		return
+	}
+	tw.status = statusCode
+}
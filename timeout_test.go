@@ -0,0 +1,74 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	lateWrite := make(chan error, 1)
+	middleware := TimeoutMiddleware(TimeoutOptions{Timeout: 20 * time.Millisecond, StatusCode: http.StatusGatewayTimeout})
+
+	fast := middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Fast", "yes")
+		RespondWithText(w, http.StatusCreated, "done")
+	}))
+	rec := httptest.NewRecorder()
+	fast.ServeHTTP(rec, httptestRequest())
+	if expected, actual := http.StatusCreated, rec.Code; actual != expected {
+		t.Errorf("Expected status-code=%v but actual=%v", expected, actual)
+	}
+	if expected, actual := "done", rec.Body.String(); actual != expected {
+		t.Errorf("Expected body=%q but actual=%q", expected, actual)
+	}
+	if expected, actual := "yes", rec.Header().Get("X-Fast"); actual != expected {
+		t.Errorf("Expected X-Fast header=%q but actual=%q", expected, actual)
+	}
+
+	slow := middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		_, err := w.Write([]byte("too late"))
+		lateWrite <- err
+	}))
+	rec = httptest.NewRecorder()
+	slow.ServeHTTP(rec, httptestRequest())
+	if expected, actual := http.StatusGatewayTimeout, rec.Code; actual != expected {
+		t.Errorf("Expected status-code=%v but actual=%v", expected, actual)
+	}
+	if expected, actual := `{"error":"Gateway Timeout"}`, rec.Body.String(); actual != expected {
+		t.Errorf("Expected body=%q but actual=%q", expected, actual)
+	}
+	if err := <-lateWrite; err != http.ErrHandlerTimeout {
+		t.Errorf("Expected late write err=%v but actual=%v", http.ErrHandlerTimeout, err)
+	}
+}
+
+func TestTimeoutMiddlewareDefaultTimeout(t *testing.T) {
+	var deadline time.Time
+	handler := TimeoutMiddleware(TimeoutOptions{})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		deadline, _ = req.Context().Deadline()
+		RespondWithText(w, http.StatusOK, "done")
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptestRequest())
+	if expected, actual := http.StatusOK, rec.Code; actual != expected {
+		t.Errorf("Expected status-code=%v with zero Timeout but actual=%v", expected, actual)
+	}
+	if remaining := time.Until(deadline); remaining < DefaultTimeout-time.Second {
+		t.Errorf("Expected deadline about DefaultTimeout=%s away but remaining=%s", DefaultTimeout, remaining)
+	}
+}
+
+func TestTimeoutMiddlewarePanic(t *testing.T) {
+	handler := RecoveryMiddleware(RecoveryOptions{})(TimeoutMiddleware(TimeoutOptions{Timeout: time.Second})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	})))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptestRequest())
+	if expected, actual := http.StatusInternalServerError, rec.Code; actual != expected {
+		t.Errorf("Expected panic to surface as status-code=%v but actual=%v", expected, actual)
+	}
+}
@@ -0,0 +1,316 @@
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gigawatt.io/errorlib"
+)
+
+// DefaultTlsReloadInterval is how often certificate files are checked for
+// changes.
+const DefaultTlsReloadInterval = 10 * time.Second
+
+var (
+	NoCertificatesError    = errors.New("at least one certificate is required")
+	Http2CipherSuitesError = errors.New("h2 requires TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 in CipherSuites when TLS 1.2 is allowed")
+)
+
+// CertificateFiles is a PEM certificate chain and private key pair.
+type CertificateFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+// TlsOptions configures HTTPS for WebServer.
+type TlsOptions struct {
+	Certificates   []CertificateFiles // selected by SNI against each certificate's names; the first is the default.
+	MinVersion     uint16             // minimum protocol version, tls.VersionTLS12 if 0.
+	CipherSuites   []uint16           // optional TLS 1.2 cipher suite policy, Go's defaults if empty; h2 is only offered if it has an AES-128-GCM suite.
+	ReloadInterval time.Duration      // how often to check the files for changes, DefaultTlsReloadInterval if 0, never if negative.
+	RedirectAddr   string             // optional plaintext address which redirects requests to HTTPS, e.g. ":80".
+}
+
+// CertificateStore serves certificates loaded from files, choosing among
+// them by SNI server name.  Once started the files are reloaded when they
+// change or the process receives SIGHUP; when a reload fails the previous
+// certificates stay in service.
+type CertificateStore struct {
+	files    []CertificateFiles
+	interval time.Duration
+	certs    []*tls.Certificate
+	modTimes []time.Time
+	stopChan chan struct{}
+	lock     sync.RWMutex
+}
+
+// NewCertificateStore loads the certificates.  An interval of 0 means
+// DefaultTlsReloadInterval, and a negative one disables polling.
+func NewCertificateStore(files []CertificateFiles, interval time.Duration) (*CertificateStore, error) {
+	if len(files) == 0 {
+		return nil, NoCertificatesError
+	}
+	if interval == 0 {
+		interval = DefaultTlsReloadInterval
+	}
+	store := &CertificateStore{
+		files:    files,
+		interval: interval,
+	}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload unconditionally re-reads all of the certificate files.
+func (store *CertificateStore) Reload() error {
+	certs := make([]*tls.Certificate, len(store.files))
+	modTimes := make([]time.Time, len(store.files))
+	for i, files := range store.files {
+		cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return fmt.Errorf("%s: %s", files.CertFile, err)
+		}
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("%s: %s", files.CertFile, err)
+		}
+		certs[i] = &cert
+		if modTimes[i], err = certModTime(files); err != nil {
+			return err
+		}
+	}
+	store.lock.Lock()
+	store.certs = certs
+	store.modTimes = modTimes
+	store.lock.Unlock()
+	return nil
+}
+
+// certModTime is the later of the certificate and key modification times.
+func certModTime(files CertificateFiles) (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{files.CertFile, files.KeyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate satisfies tls.Config.GetCertificate.
+func (store *CertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	serverName := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if len(serverName) > 0 {
+		// Exact names take precedence over wildcards.
+		for _, cert := range store.certs {
+			for _, name := range cert.Leaf.DNSNames {
+				if strings.ToLower(name) == serverName {
+					return cert, nil
+				}
+			}
+		}
+		if i := strings.Index(serverName, "."); i > 0 {
+			wildcard := "*" + serverName[i:]
+			for _, cert := range store.certs {
+				for _, name := range cert.Leaf.DNSNames {
+					if strings.ToLower(name) == wildcard {
+						return cert, nil
+					}
+				}
+			}
+		}
+	}
+	return store.certs[0], nil
+}
+
+// Start begins watching for changed files and SIGHUP.
+func (store *CertificateStore) Start() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if store.stopChan != nil {
+		return errorlib.AlreadyRunningError
+	}
+	store.stopChan = make(chan struct{})
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go store.watch(hup, store.stopChan)
+	return nil
+}
+
+// Stop terminates watching.
+func (store *CertificateStore) Stop() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if store.stopChan == nil {
+		return errorlib.NotRunningError
+	}
+	close(store.stopChan)
+	store.stopChan = nil
+	return nil
+}
+
+func (store *CertificateStore) watch(hup chan os.Signal, stopChan chan struct{}) {
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if store.interval > 0 {
+		ticker := time.NewTicker(store.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var (
+		retry     bool   // The last reload failed, e.g. due to a partially written file.
+		lastError string // Each distinct failure is only logged once.
+	)
+	reload := func() {
+		if err := store.Reload(); err != nil {
+			retry = true
+			if err.Error() != lastError {
+				logger.Errorf("web.CertificateStore: error reloading certificates, keeping previous ones: %s", err)
+				lastError = err.Error()
+			}
+			return
+		}
+		retry = false
+		lastError = ""
+	}
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-hup:
+			logger.Infof("web.CertificateStore: SIGHUP received, reloading certificates")
+			lastError = ""
+			reload()
+		case <-tick:
+			// Keep retrying after a failure rather than waiting for the mtimes
+			// to move on, since the completed files may share the mtimes of the
+			// partial ones which failed.
+			if retry || store.changed() {
+				reload()
+			}
+		}
+	}
+}
+
+func (store *CertificateStore) changed() bool {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	for i, files := range store.files {
+		modTime, err := certModTime(files)
+		if err != nil {
+			logger.Errorf("web.CertificateStore: error checking %s: %s", files.CertFile, err)
+			continue
+		}
+		if !modTime.Equal(store.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsConfig combines Options.TLSConfig and Options.Tls, returning nil when
+// neither is set.  The returned store, if any, needs starting.
+func (ws *WebServer) tlsConfig() (*tls.Config, *CertificateStore, error) {
+	if ws.Options.TLSConfig == nil && ws.Options.Tls == nil {
+		return nil, nil, nil
+	}
+	config := &tls.Config{}
+	if ws.Options.TLSConfig != nil {
+		config = ws.Options.TLSConfig.Clone()
+	}
+	var store *CertificateStore
+	if options := ws.Options.Tls; options != nil {
+		if len(options.Certificates) > 0 {
+			var err error
+			if store, err = NewCertificateStore(options.Certificates, options.ReloadInterval); err != nil {
+				return nil, nil, err
+			}
+			config.GetCertificate = store.GetCertificate
+		}
+		if options.MinVersion != 0 {
+			config.MinVersion = options.MinVersion
+		}
+		if len(options.CipherSuites) > 0 {
+			config.CipherSuites = options.CipherSuites
+		}
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if len(config.NextProtos) == 0 {
+		// As http.Server.ServeTLS would, so that HTTP/2 is negotiated when the
+		// cipher policy permits it.
+		config.NextProtos = []string{"http/1.1"}
+		if http2CipherSuitesOk(config) {
+			config.NextProtos = []string{"h2", "http/1.1"}
+		}
+	}
+	for _, proto := range config.NextProtos {
+		// Otherwise http.Server.Serve refuses to start.
+		if proto == "h2" && !http2CipherSuitesOk(config) {
+			return nil, nil, Http2CipherSuitesError
+		}
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return nil, nil, NoCertificatesError
+	}
+	return config, store, nil
+}
+
+// http2CipherSuitesOk reports whether config's cipher policy includes one of
+// the suites HTTP/2 requires of TLS 1.2 connections (RFC 7540 section 9.2.2).
+func http2CipherSuitesOk(config *tls.Config) bool {
+	if len(config.CipherSuites) == 0 || config.MinVersion >= tls.VersionTLS13 {
+		return true
+	}
+	for _, suite := range config.CipherSuites {
+		if suite == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || suite == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			return true
+		}
+	}
+	return false
+}
+
+// HttpsRedirectHandler redirects requests to the same host and URI over
+// HTTPS, on httpsPort unless it's the default of 443.
+func HttpsRedirectHandler(httpsPort string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6 literal.
+		}
+		if len(httpsPort) > 0 && httpsPort != "443" {
+			host += ":" + httpsPort
+		}
+		status := http.StatusMovedPermanently
+		if req.Method != "GET" && req.Method != "HEAD" {
+			// Preserve the method and body.
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), status)
+	}
+}
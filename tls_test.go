@@ -0,0 +1,480 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeTestCert generates a self-signed certificate for names, writing it and
+// its key under dir and returning the files and a pool trusting it.
+func writeTestCert(t *testing.T, dir string, prefix string, serial int64, names ...string) (CertificateFiles, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: names[0]},
+		DNSNames:              names,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := CertificateFiles{
+		CertFile: filepath.Join(dir, prefix+".crt"),
+		KeyFile:  filepath.Join(dir, prefix+".key"),
+	}
+	if err := ioutil.WriteFile(files.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(files.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return files, pool
+}
+
+func tlsClient(pool *x509.CertPool, serverName string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: pool, ServerName: serverName},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// servedSerial fetches the serial number of the certificate served for
+// serverName.
+func servedSerial(t *testing.T, server *WebServer, serverName string) int64 {
+	conn, err := tls.Dial("tcp", server.Addr().String(), &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestWebServerTls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defaultFiles, defaultPool := writeTestCert(t, dir, "default", 1, "example.com")
+	wildcardFiles, wildcardPool := writeTestCert(t, dir, "wildcard", 2, "*.example.org")
+	exactFiles, _ := writeTestCert(t, dir, "exact", 3, "www.example.org")
+
+	server := NewStaticWebServer(WebServerOptions{
+		Addr: testAddr,
+		Tls: &TlsOptions{
+			Certificates: []CertificateFiles{defaultFiles, wildcardFiles, exactFiles},
+		},
+	}, []byte("secure"), http.StatusOK, nil)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	if expected, actual := "https://"+server.Addr().String(), server.BaseUrl(); actual != expected {
+		t.Errorf("Expected BaseUrl=%v but actual=%v", expected, actual)
+	}
+
+	resp, err := tlsClient(defaultPool, "example.com").Get(server.BaseUrl() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if expected, actual := "secure", string(body); actual != expected {
+		t.Errorf("Expected body=%q but actual=%q", expected, actual)
+	}
+	if resp.TLS == nil {
+		t.Errorf("Expected response to have been served over TLS")
+	}
+
+	testCases := []struct {
+		serverName string
+		expected   int64
+	}{
+		{serverName: "example.com", expected: 1},
+		{serverName: "", expected: 1},
+		{serverName: "unknown.net", expected: 1},
+		{serverName: "api.example.org", expected: 2},
+		{serverName: "WWW.example.org", expected: 3},
+		{serverName: "a.b.example.org", expected: 1},
+	}
+	for i, testCase := range testCases {
+		if actual := servedSerial(t, server, testCase.serverName); actual != testCase.expected {
+			t.Errorf("[i=%v] Expected serverName=%q to be served serial=%v but actual=%v", i, testCase.serverName, testCase.expected, actual)
+		}
+	}
+
+	if _, err := tlsClient(wildcardPool, "api.example.org").Get(server.BaseUrl() + "/"); err != nil {
+		t.Errorf("Expected SNI certificate to verify but got error: %s", err)
+	}
+
+	// Plaintext requests must fail.
+	if resp, err := http.Get(fmt.Sprintf("http://%s/", server.Addr())); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("Expected plaintext request to fail")
+		}
+	}
+}
+
+func TestWebServerTlsMinVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files, pool := writeTestCert(t, dir, "server", 1, "example.com")
+	server := NewStaticWebServer(WebServerOptions{
+		Addr: testAddr,
+		Tls: &TlsOptions{
+			Certificates: []CertificateFiles{files},
+			MinVersion:   tls.VersionTLS13,
+		},
+	}, []byte("secure"), http.StatusOK, nil)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	config := &tls.Config{RootCAs: pool, ServerName: "example.com", MaxVersion: tls.VersionTLS12}
+	if conn, err := tls.Dial("tcp", server.Addr().String(), config); err == nil {
+		conn.Close()
+		t.Errorf("Expected TLS 1.2 handshake to be refused when MinVersion is TLS 1.3")
+	}
+	config.MaxVersion = 0
+	conn, err := tls.Dial("tcp", server.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := uint16(tls.VersionTLS13), conn.ConnectionState().Version; actual != expected {
+		t.Errorf("Expected version=%x but actual=%x", expected, actual)
+	}
+	conn.Close()
+}
+
+// TestWebServerTlsCipherSuites ensures a TLS 1.2 cipher policy is enforced,
+// including one without the AES-128-GCM suites HTTP/2 requires.
+func TestWebServerTlsCipherSuites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files, pool := writeTestCert(t, dir, "server", 1, "example.com")
+	server := NewStaticWebServer(WebServerOptions{
+		Addr: testAddr,
+		Tls: &TlsOptions{
+			Certificates: []CertificateFiles{files},
+			CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+		},
+	}, []byte("secure"), http.StatusOK, nil)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	config := &tls.Config{
+		RootCAs:      pool,
+		ServerName:   "example.com",
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}
+	if conn, err := tls.Dial("tcp", server.Addr().String(), config); err == nil {
+		conn.Close()
+		t.Errorf("Expected TLS 1.2 handshake with a suite outside the policy to be refused")
+	}
+	config.CipherSuites = nil
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+	response, err := client.Get(server.BaseUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if expected, actual := uint16(tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384), response.TLS.CipherSuite; actual != expected {
+		t.Errorf("Expected cipher suite=%s but actual=%s", tls.CipherSuiteName(expected), tls.CipherSuiteName(actual))
+	}
+	if body, err := ioutil.ReadAll(response.Body); err != nil || string(body) != "secure" {
+		t.Errorf("Expected body=%q but actual=%q err=%v", "secure", string(body), err)
+	}
+}
+
+func TestWebServerTlsReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files, _ := writeTestCert(t, dir, "server", 1, "example.com")
+	server := NewStaticWebServer(WebServerOptions{
+		Addr: testAddr,
+		Tls: &TlsOptions{
+			Certificates:   []CertificateFiles{files},
+			ReloadInterval: 10 * time.Millisecond,
+		},
+	}, []byte("secure"), http.StatusOK, nil)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	if expected, actual := int64(1), servedSerial(t, server, "example.com"); actual != expected {
+		t.Fatalf("Expected serial=%v but actual=%v", expected, actual)
+	}
+
+	// Ensure the new files' modification time differs on coarse filesystems.
+	time.Sleep(10 * time.Millisecond)
+	writeTestCert(t, dir, "server", 2, "example.com")
+	later := time.Now().Add(time.Second)
+	os.Chtimes(files.CertFile, later, later)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for servedSerial(t, server, "example.com") != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the changed certificate to be served")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A broken revision leaves the previous certificate in service.
+	if err := ioutil.WriteFile(files.CertFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Certificates().Reload(); err == nil {
+		t.Errorf("Expected reload of broken certificate to fail")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if expected, actual := int64(2), servedSerial(t, server, "example.com"); actual != expected {
+		t.Errorf("Expected previous serial=%v to remain in service but actual=%v", expected, actual)
+	}
+}
+
+// TestWebServerTlsReloadSameModTime ensures certificates which are completed
+// within the same mtime tick as a partial write which failed to load are
+// still picked up.
+func TestWebServerTlsReloadSameModTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files, _ := writeTestCert(t, dir, "server", 1, "example.com")
+	server := NewStaticWebServer(WebServerOptions{
+		Addr: testAddr,
+		Tls: &TlsOptions{
+			Certificates:   []CertificateFiles{files},
+			ReloadInterval: 10 * time.Millisecond,
+		},
+	}, []byte("secure"), http.StatusOK, nil)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	modTime := time.Now().Add(time.Second)
+	chtimes := func() {
+		for _, name := range []string{files.CertFile, files.KeyFile} {
+			if err := os.Chtimes(name, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := ioutil.WriteFile(files.CertFile, []byte("-----BEGIN CERT"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	chtimes()
+	time.Sleep(50 * time.Millisecond) // Let the watcher fail on the partial file.
+	writeTestCert(t, dir, "server", 2, "example.com")
+	chtimes()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for servedSerial(t, server, "example.com") != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the completed certificate to be served")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebServerTlsRedirect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files, _ := writeTestCert(t, dir, "server", 1, "example.com")
+	server := NewStaticWebServer(WebServerOptions{
+		Addr: testAddr,
+		Tls: &TlsOptions{
+			Certificates: []CertificateFiles{files},
+			RedirectAddr: testAddr,
+		},
+	}, []byte("secure"), http.StatusOK, nil)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	port := server.Addr().String()[strings.LastIndex(server.Addr().String(), ":"):]
+	testCases := []struct {
+		method         string
+		expectedStatus int
+	}{
+		{method: "GET", expectedStatus: http.StatusMovedPermanently},
+		{method: "POST", expectedStatus: http.StatusPermanentRedirect},
+	}
+	client := tlsClient(nil, "")
+	for i, testCase := range testCases {
+		req, err := http.NewRequest(testCase.method, fmt.Sprintf("http://%s/some/path?q=1", server.RedirectAddr()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = "example.com"
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != testCase.expectedStatus {
+			t.Errorf("[i=%v] Expected status=%v but actual=%v", i, testCase.expectedStatus, resp.StatusCode)
+		}
+		if expected, actual := "https://example.com"+port+"/some/path?q=1", resp.Header.Get("Location"); actual != expected {
+			t.Errorf("[i=%v] Expected Location=%v but actual=%v", i, expected, actual)
+		}
+	}
+
+	redirectAddr := server.RedirectAddr().String()
+	if err := server.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := http.Get("http://" + redirectAddr + "/"); err == nil {
+		resp.Body.Close()
+		t.Errorf("Expected redirect listener to be closed by Stop")
+	}
+}
+
+func TestHttpsRedirectHandler(t *testing.T) {
+	testCases := []struct {
+		host     string
+		port     string
+		expected string
+	}{
+		{host: "example.com", port: "443", expected: "https://example.com/x"},
+		{host: "example.com:80", port: "", expected: "https://example.com/x"},
+		{host: "example.com:8080", port: "8443", expected: "https://example.com:8443/x"},
+		{host: "[::1]:80", port: "8443", expected: "https://[::1]:8443/x"},
+	}
+	for i, testCase := range testCases {
+		req, _ := http.NewRequest("GET", "http://"+testCase.host+"/x", nil)
+		rr := httptest.NewRecorder()
+		HttpsRedirectHandler(testCase.port)(rr, req)
+		if actual := rr.Header().Get("Location"); actual != testCase.expected {
+			t.Errorf("[i=%v] Expected Location=%v but actual=%v", i, testCase.expected, actual)
+		}
+	}
+}
+
+func TestWebServerTlsErrors(t *testing.T) {
+	testCases := []WebServerOptions{
+		{Addr: testAddr, Tls: &TlsOptions{}},
+		{Addr: testAddr, TLSConfig: &tls.Config{}},
+		{Addr: testAddr, Tls: &TlsOptions{Certificates: []CertificateFiles{{CertFile: "/nonexistent.crt", KeyFile: "/nonexistent.key"}}}},
+		{Addr: testAddr, TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{{}},
+			NextProtos:   []string{"h2"},
+			CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+		}},
+	}
+	for i, options := range testCases {
+		server := NewWebServer(options)
+		if err := server.Start(); err == nil {
+			server.Stop()
+			t.Errorf("[i=%v] Expected Start to fail with options=%+v", i, options)
+		}
+	}
+}
+
+func TestCertificateStoreSighup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files, _ := writeTestCert(t, dir, "server", 1, "example.com")
+	store, err := NewCertificateStore([]CertificateFiles{files}, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Stop()
+
+	writeTestCert(t, dir, "server", 2, "example.com")
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Signal(syscall.SIGHUP); err != nil {
+		t.Skipf("SIGHUP unsupported: %s", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.Leaf.SerialNumber.Int64() == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for SIGHUP to reload the certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Exporter receives spans once they've ended.  Exporters are called on the
+// request goroutine, so slow backends should buffer internally.
+type Exporter interface {
+	Export(span *Span) error
+}
+
+// JsonExporter writes each span as a line of JSON.
+type JsonExporter struct {
+	writer io.Writer
+	lock   sync.Mutex
+}
+
+// NewJsonExporter creates a JsonExporter writing to w.
+func NewJsonExporter(w io.Writer) *JsonExporter {
+	exporter := &JsonExporter{
+		writer: w,
+	}
+	return exporter
+}
+
+// NewStdoutExporter creates a JsonExporter writing to os.Stdout.
+func NewStdoutExporter() *JsonExporter {
+	return NewJsonExporter(os.Stdout)
+}
+
+// Export satisfies the Exporter interface.
+func (exporter *JsonExporter) Export(span *Span) error {
+	b, err := json.Marshal(span)
+	if err != nil {
+		return err
+	}
+	exporter.lock.Lock()
+	defer exporter.lock.Unlock()
+	_, err = exporter.writer.Write(append(b, '\n'))
+	return err
+}
+
+// MemoryExporter keeps exported spans in memory, for tests.
+type MemoryExporter struct {
+	spans []*Span
+	lock  sync.Mutex
+}
+
+// NewMemoryExporter creates an empty MemoryExporter.
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+// Export satisfies the Exporter interface.
+func (exporter *MemoryExporter) Export(span *Span) error {
+	exporter.lock.Lock()
+	defer exporter.lock.Unlock()
+	exporter.spans = append(exporter.spans, span)
+	return nil
+}
+
+// Spans returns the spans exported so far, in order.
+func (exporter *MemoryExporter) Spans() []*Span {
+	exporter.lock.Lock()
+	defer exporter.lock.Unlock()
+	return append([]*Span(nil), exporter.spans...)
+}
+
+// Reset discards all exported spans.
+func (exporter *MemoryExporter) Reset() {
+	exporter.lock.Lock()
+	defer exporter.lock.Unlock()
+	exporter.spans = nil
+}
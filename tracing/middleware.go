@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gigawattio/web"
+)
+
+// Options configures Middleware.
+type Options struct {
+	Exporter    Exporter                     // receives sampled spans, required.
+	ServiceName string                       // optional, recorded as the service.name attribute.
+	Sampler     func(req *http.Request) bool // decides whether new traces are sampled, all are if nil.
+}
+
+// Middleware generates a middleware function which creates a server span for
+// each request.
+//
+// A valid incoming traceparent makes the span a child of the caller's span
+// and the caller's sampling decision is honored; otherwise a new trace is
+// started.  The span is named after the matched route pattern, e.g.
+// "GET /users/:id", records the response status, and collects every error
+// passed to web.JsonErrorFor (which includes the generics endpoints).  5xx
+// responses and panics mark the span as an error.
+//
+// Handlers can reach the span with SpanFromContext(req.Context()) and pass
+// the trace on to downstream services with Inject.
+func Middleware(options Options) web.MiddlewareFunc {
+	if options.Exporter == nil {
+		panic("tracing: Options.Exporter is required")
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			span := &Span{
+				Kind:   "server",
+				SpanId: NewSpanId(),
+				Start:  time.Now(),
+				Status: StatusUnset,
+			}
+			if parent, ok := Extract(req.Header); ok {
+				span.TraceId = parent.TraceId
+				span.ParentSpanId = &parent.SpanId
+				span.TraceState = parent.TraceState
+				span.Sampled = parent.Sampled()
+			} else {
+				span.TraceId = NewTraceId()
+				span.Sampled = options.Sampler == nil || options.Sampler(req)
+			}
+			span.SetAttribute("http.method", req.Method)
+			span.SetAttribute("http.target", req.URL.RequestURI())
+			span.SetAttribute("http.client_ip", web.ClientIp(req))
+			if len(options.ServiceName) > 0 {
+				span.SetAttribute("service.name", options.ServiceName)
+			}
+
+			req = web.TrackRoutePattern(req)
+			req = web.WithErrorRecorder(req, span)
+			req = req.WithContext(ContextWithSpan(req.Context(), span))
+			tw := web.NewTrackingResponseWriter(w)
+
+			defer func() {
+				p := recover()
+				finish(span, req, tw, p, options.Exporter)
+				if p != nil {
+					panic(p)
+				}
+			}()
+			next.ServeHTTP(tw, req)
+		})
+	}
+}
+
+func finish(span *Span, req *http.Request, tw *web.TrackingResponseWriter, p interface{}, exporter Exporter) {
+	span.End = time.Now()
+	if route := web.RoutePattern(req); len(route) > 0 {
+		span.Name = req.Method + " " + route
+		span.SetAttribute("http.route", route)
+	} else {
+		span.Name = req.Method
+	}
+	if id := web.RequestId(req); len(id) > 0 {
+		span.SetAttribute("http.request_id", id)
+	}
+	status := tw.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	switch {
+	case p != nil:
+		span.RecordError(fmt.Errorf("panic: %v", p))
+		span.SetStatus(StatusError, "")
+		status = http.StatusInternalServerError
+	case status >= 500:
+		span.SetStatus(StatusError, http.StatusText(status))
+	}
+	span.SetAttribute("http.status_code", status)
+	if !span.Sampled {
+		return
+	}
+	if err := exporter.Export(span); err != nil {
+		web.RequestLogger(req).Errorf("tracing: error exporting span trace=%s span=%s: %s", span.TraceId, span.SpanId, err)
+	}
+}
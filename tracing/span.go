@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StatusCode is the outcome of a span.
+type StatusCode string
+
+const (
+	StatusUnset StatusCode = "unset"
+	StatusOk    StatusCode = "ok"
+	StatusError StatusCode = "error"
+)
+
+// Event is a timestamped annotation on a span, e.g. a recorded error.
+type Event struct {
+	Name       string                 `json:"name"`
+	Time       time.Time              `json:"time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Span is a single timed operation within a trace.  Once ended, a span is
+// handed to the Exporter and must no longer be modified.
+type Span struct {
+	Name          string                 `json:"name"`
+	Kind          string                 `json:"kind"`
+	TraceId       TraceId                `json:"traceId"`
+	SpanId        SpanId                 `json:"spanId"`
+	ParentSpanId  *SpanId                `json:"parentSpanId,omitempty"`
+	TraceState    string                 `json:"traceState,omitempty"`
+	Sampled       bool                   `json:"sampled"`
+	Start         time.Time              `json:"start"`
+	End           time.Time              `json:"end"`
+	Status        StatusCode             `json:"status"`
+	StatusMessage string                 `json:"statusMessage,omitempty"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Events        []Event                `json:"events,omitempty"`
+
+	lock sync.Mutex
+}
+
+// SpanContext returns the propagation context identifying this span.
+func (span *Span) SpanContext() SpanContext {
+	sc := SpanContext{
+		TraceId:    span.TraceId,
+		SpanId:     span.SpanId,
+		TraceState: span.TraceState,
+	}
+	if span.Sampled {
+		sc.Flags = FlagSampled
+	}
+	return sc
+}
+
+// SetAttribute records a key/value pair on the span.
+func (span *Span) SetAttribute(key string, value interface{}) {
+	span.lock.Lock()
+	defer span.lock.Unlock()
+	if span.Attributes == nil {
+		span.Attributes = map[string]interface{}{}
+	}
+	span.Attributes[key] = value
+}
+
+// RecordError adds an "exception" event to the span.  It satisfies
+// web.ErrorRecorder, which is how errors passed to web.JsonErrorFor reach
+// the span.
+func (span *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	span.AddEvent("exception", map[string]interface{}{"exception.message": err.Error()})
+	span.lock.Lock()
+	if len(span.StatusMessage) == 0 {
+		span.StatusMessage = err.Error()
+	}
+	span.lock.Unlock()
+}
+
+// AddEvent adds a timestamped event to the span.
+func (span *Span) AddEvent(name string, attributes map[string]interface{}) {
+	span.lock.Lock()
+	defer span.lock.Unlock()
+	span.Events = append(span.Events, Event{Name: name, Time: time.Now(), Attributes: attributes})
+}
+
+// SetStatus sets the span's outcome.
+func (span *Span) SetStatus(code StatusCode, message string) {
+	span.lock.Lock()
+	defer span.lock.Unlock()
+	span.Status = code
+	if len(message) > 0 {
+		span.StatusMessage = message
+	}
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a child context carrying span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
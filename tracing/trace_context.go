@@ -0,0 +1,173 @@
+// Package tracing provides server-side distributed tracing with W3C Trace
+// Context (https://www.w3.org/TR/trace-context/) propagation and pluggable
+// span exporters.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gigawattio/web/logging"
+)
+
+var logger = logging.For("tracing")
+
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+
+	// MaxTracestateLength is the longest tracestate which will be propagated.
+	MaxTracestateLength = 512
+
+	// FlagSampled is the trace-flags bit indicating the caller recorded its span.
+	FlagSampled byte = 0x01
+)
+
+var InvalidTraceparentError = errors.New("invalid traceparent")
+
+// TraceId identifies a whole trace.
+type TraceId [16]byte
+
+// SpanId identifies a single span within a trace.
+type SpanId [8]byte
+
+func (id TraceId) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanId) String() string  { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the id is non-zero.
+func (id TraceId) IsValid() bool { return id != TraceId{} }
+
+// IsValid reports whether the id is non-zero.
+func (id SpanId) IsValid() bool { return id != SpanId{} }
+
+// MarshalText renders the id as lowercase hex, e.g. for JSON exporters.
+func (id TraceId) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+
+// MarshalText renders the id as lowercase hex, e.g. for JSON exporters.
+func (id SpanId) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+
+// NewTraceId generates a random trace id.
+func NewTraceId() TraceId {
+	var id TraceId
+	for !id.IsValid() {
+		if _, err := rand.Read(id[:]); err != nil {
+			logger.Errorf("tracing: error reading random bytes: %s", err)
+		}
+	}
+	return id
+}
+
+// NewSpanId generates a random span id.
+func NewSpanId() SpanId {
+	var id SpanId
+	for !id.IsValid() {
+		if _, err := rand.Read(id[:]); err != nil {
+			logger.Errorf("tracing: error reading random bytes: %s", err)
+		}
+	}
+	return id
+}
+
+// SpanContext is the part of a span which propagates across process
+// boundaries.
+type SpanContext struct {
+	TraceId    TraceId
+	SpanId     SpanId
+	Flags      byte
+	TraceState string
+}
+
+// Sampled reports whether the sampled flag is set.
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// Traceparent renders the traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceId.String() + "-" + sc.SpanId.String() + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+// ParseTraceparent parses a traceparent header value, e.g.
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+//
+// Versions newer than 00 are accepted as long as they start with the
+// version 00 fields, as the spec requires.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	s = strings.TrimSpace(s)
+	if len(s) < 55 || (len(s) > 55 && s[55] != '-') {
+		return sc, InvalidTraceparentError
+	}
+	if s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, InvalidTraceparentError
+	}
+	version, err := decodeLowerHex(s[0:2])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(s) != 55) {
+		return sc, InvalidTraceparentError
+	}
+	traceId, err := decodeLowerHex(s[3:35])
+	if err != nil {
+		return sc, InvalidTraceparentError
+	}
+	spanId, err := decodeLowerHex(s[36:52])
+	if err != nil {
+		return sc, InvalidTraceparentError
+	}
+	flags, err := decodeLowerHex(s[53:55])
+	if err != nil {
+		return sc, InvalidTraceparentError
+	}
+	copy(sc.TraceId[:], traceId)
+	copy(sc.SpanId[:], spanId)
+	sc.Flags = flags[0]
+	if !sc.TraceId.IsValid() || !sc.SpanId.IsValid() {
+		return SpanContext{}, InvalidTraceparentError
+	}
+	return sc, nil
+}
+
+func decodeLowerHex(s string) ([]byte, error) {
+	if strings.ToLower(s) != s {
+		return nil, InvalidTraceparentError
+	}
+	return hex.DecodeString(s)
+}
+
+// Extract reads the caller's span context from request headers.
+func Extract(h http.Header) (SpanContext, bool) {
+	values := h[http.CanonicalHeaderKey(TraceparentHeader)]
+	if len(values) != 1 {
+		return SpanContext{}, false
+	}
+	sc, err := ParseTraceparent(values[0])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	if state := strings.Join(h[http.CanonicalHeaderKey(TracestateHeader)], ","); len(state) <= MaxTracestateLength {
+		sc.TraceState = state
+	}
+	return sc, true
+}
+
+// Inject writes the span context of the span in ctx to outgoing request
+// headers, so that downstream services join the trace, e.g.:
+//
+//	outReq = outReq.WithContext(req.Context())
+//	tracing.Inject(outReq.Context(), outReq.Header)
+func Inject(ctx context.Context, h http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	sc := span.SpanContext()
+	h.Set(TraceparentHeader, sc.Traceparent())
+	if len(sc.TraceState) > 0 {
+		h.Set(TracestateHeader, sc.TraceState)
+	} else {
+		h.Del(TracestateHeader)
+	}
+}
@@ -0,0 +1,147 @@
+package tracing_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gigawattio/web"
+	"github.com/gigawattio/web/route"
+	"github.com/gigawattio/web/tracing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	testCases := []struct {
+		input         string
+		expectedValid bool
+		expectedTrace string
+		expectedFlags byte
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, "4bf92f3577b34da6a3ce929d0e0e4736", 1},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, "4bf92f3577b34da6a3ce929d0e0e4736", 0},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true, "4bf92f3577b34da6a3ce929d0e0e4736", 1},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, "", 0},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, "", 0},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, "", 0},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, "", 0},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, "", 0},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, "", 0},
+		{"garbage", false, "", 0},
+	}
+	for i, testCase := range testCases {
+		sc, err := tracing.ParseTraceparent(testCase.input)
+		if expected, actual := testCase.expectedValid, err == nil; actual != expected {
+			t.Errorf("[i=%v] Expected valid=%v for %q but actual=%v (err=%v)", i, expected, testCase.input, actual, err)
+			continue
+		}
+		if !testCase.expectedValid {
+			continue
+		}
+		if expected, actual := testCase.expectedTrace, sc.TraceId.String(); actual != expected {
+			t.Errorf("[i=%v] Expected trace-id=%q but actual=%q", i, expected, actual)
+		}
+		if expected, actual := testCase.expectedFlags, sc.Flags; actual != expected {
+			t.Errorf("[i=%v] Expected flags=%v but actual=%v", i, expected, actual)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	exporter := tracing.NewMemoryExporter()
+	var downstream http.Header
+	router := route.Activate([]route.RouteMiddlewareBundle{
+		{
+			RouteData: []route.RouteDatum{
+				{Reciever: "get", Path: "/users/:id", HandlerFunc: func(w http.ResponseWriter, req *http.Request) {
+					downstream = http.Header{}
+					tracing.Inject(req.Context(), downstream)
+					web.RespondWithText(w, http.StatusOK, "ok")
+				}},
+				{Reciever: "post", Path: "/users", HandlerFunc: func(w http.ResponseWriter, req *http.Request) {
+					web.RespondWithJson(w, http.StatusInternalServerError, web.JsonErrorFor(req, errors.New("database unavailable")))
+				}},
+			},
+		},
+	})
+	handler := tracing.Middleware(tracing.Options{Exporter: exporter, ServiceName: "users"})(router.Handler())
+
+	// Continue an incoming trace.
+	req := httptest.NewRequest("GET", "/users/42", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "congo=t61rcWkgMzE")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.Spans()
+	if expected, actual := 1, len(spans); actual != expected {
+		t.Fatalf("Expected %v exported span but actual=%v", expected, actual)
+	}
+	span := spans[0]
+	if expected, actual := "GET /users/:id", span.Name; actual != expected {
+		t.Errorf("Expected span name=%q but actual=%q", expected, actual)
+	}
+	if expected, actual := "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceId.String(); actual != expected {
+		t.Errorf("Expected trace-id=%q but actual=%q", expected, actual)
+	}
+	if span.ParentSpanId == nil || span.ParentSpanId.String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected parent span-id=00f067aa0ba902b7 but actual=%v", span.ParentSpanId)
+	}
+	if expected, actual := 200, span.Attributes["http.status_code"]; actual != expected {
+		t.Errorf("Expected http.status_code=%v but actual=%v", expected, actual)
+	}
+	if expected, actual := "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanId.String()+"-01", downstream.Get("traceparent"); actual != expected {
+		t.Errorf("Expected propagated traceparent=%q but actual=%q", expected, actual)
+	}
+	if expected, actual := "congo=t61rcWkgMzE", downstream.Get("tracestate"); actual != expected {
+		t.Errorf("Expected propagated tracestate=%q but actual=%q", expected, actual)
+	}
+
+	// New trace, with an error reported through JsonErrorFor.
+	exporter.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/users", nil))
+	spans = exporter.Spans()
+	if expected, actual := 1, len(spans); actual != expected {
+		t.Fatalf("Expected %v exported span but actual=%v", expected, actual)
+	}
+	span = spans[0]
+	if span.ParentSpanId != nil {
+		t.Errorf("Expected root span but found parent=%v", span.ParentSpanId)
+	}
+	if expected, actual := tracing.StatusError, span.Status; actual != expected {
+		t.Errorf("Expected status=%v but actual=%v", expected, actual)
+	}
+	if len(span.Events) != 1 || span.Events[0].Attributes["exception.message"] != "database unavailable" {
+		t.Errorf("Expected JsonErrorFor error to be recorded as an event but actual=%+v", span.Events)
+	}
+
+	// Unsampled callers aren't exported.
+	exporter.Reset()
+	req = httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if actual := len(exporter.Spans()); actual != 0 {
+		t.Errorf("Expected unsampled span not to be exported but actual=%v spans", actual)
+	}
+}
+
+func TestJsonExporter(t *testing.T) {
+	var buf bytes.Buffer
+	handler := tracing.Middleware(tracing.Options{Exporter: tracing.NewJsonExporter(&buf)})(http.NotFoundHandler())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+	if !strings.HasSuffix(buf.String(), "\n") {
+		t.Fatalf("Expected a newline terminated JSON line but actual=%q", buf.String())
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := "GET", decoded["name"]; actual != expected {
+		t.Errorf("Expected name=%q but actual=%v", expected, actual)
+	}
+	if traceId, _ := decoded["traceId"].(string); len(traceId) != 32 {
+		t.Errorf("Expected hex traceId but actual=%v", decoded["traceId"])
+	}
+}
@@ -0,0 +1,104 @@
+package upgrade
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFdsStart is the first inherited descriptor; a variable so tests can
+// avoid descriptors already open in the test process.
+var listenFdsStart = 3
+
+type inheritedListener struct {
+	name     string
+	listener net.Listener
+}
+
+var (
+	inherited     []inheritedListener
+	readyFile     *os.File
+	inheritOnce   sync.Once
+	inheritedLock sync.Mutex
+)
+
+// inherit consumes the environment set up by Upgrade, if any.
+func inherit() {
+	inheritOnce.Do(func() {
+		defer func() {
+			os.Unsetenv(EnvFds)
+			os.Unsetenv(EnvNames)
+			os.Unsetenv(EnvReadyFd)
+		}()
+		if fd, err := strconv.Atoi(os.Getenv(EnvReadyFd)); err == nil && fd >= listenFdsStart {
+			readyFile = os.NewFile(uintptr(fd), "upgrade-ready")
+		}
+		count, err := strconv.Atoi(os.Getenv(EnvFds))
+		if err != nil || count <= 0 {
+			return
+		}
+		names := strings.Split(os.Getenv(EnvNames), ",")
+		for i := 0; i < count; i++ {
+			var name string
+			if i < len(names) {
+				name, _ = url.QueryUnescape(names[i])
+			}
+			f := os.NewFile(uintptr(listenFdsStart+i), name)
+			l, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				logger.Errorf("upgrade: error adopting inherited listener %q: %s", name, err)
+				continue
+			}
+			inherited = append(inherited, inheritedListener{name: name, listener: l})
+		}
+	})
+}
+
+// IsUpgrade reports whether this process was started by Upgrade and has yet to
+// call Ready.
+func IsUpgrade() bool {
+	inherit()
+	inheritedLock.Lock()
+	defer inheritedLock.Unlock()
+	return readyFile != nil
+}
+
+// Inherited claims a listener passed by the previous process under name.  Each
+// listener can be claimed once.
+func Inherited(name string) (net.Listener, bool) {
+	inherit()
+	inheritedLock.Lock()
+	defer inheritedLock.Unlock()
+	for i, il := range inherited {
+		if il.name == name {
+			inherited = append(inherited[:i:i], inherited[i+1:]...)
+			return il.listener, true
+		}
+	}
+	return nil, false
+}
+
+// Ready tells the previous process that this one is serving, so that it can
+// drain and exit.  Unclaimed inherited listeners are closed.  It does nothing
+// when this process wasn't started by Upgrade.
+func Ready() error {
+	inherit()
+	inheritedLock.Lock()
+	defer inheritedLock.Unlock()
+	for _, il := range inherited {
+		logger.Warnf("upgrade: closing unclaimed inherited listener %q", il.name)
+		il.listener.Close()
+	}
+	inherited = nil
+	if readyFile == nil {
+		return nil
+	}
+	_, err := readyFile.Write([]byte{1})
+	readyFile.Close()
+	readyFile = nil
+	return err
+}
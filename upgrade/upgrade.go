@@ -0,0 +1,237 @@
+// Package upgrade implements zero-downtime binary upgrades: the running
+// process starts a new copy of its executable, passing it the listening
+// sockets as inherited file descriptors, and waits for it to report readiness
+// before draining and exiting.
+//
+// web.WebServer registers its listeners here and adopts inherited ones
+// automatically, and cli.Cli triggers Upgrade on SIGUSR2, so services built on
+// those need no changes beyond being restartable from their own command line.
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigawattio/web/logging"
+)
+
+// Environment variables describing the inherited descriptors.
+const (
+	EnvFds     = "UPGRADE_FDS"      // number of inherited listeners, starting at fd 3.
+	EnvNames   = "UPGRADE_FDNAMES"  // comma separated, query escaped listener names.
+	EnvReadyFd = "UPGRADE_READY_FD" // descriptor to write to once ready.
+)
+
+// DefaultReadyTimeout is how long Upgrade waits for the new process.
+const DefaultReadyTimeout = 30 * time.Second
+
+var (
+	NoListenersError         = errors.New("upgrade: no listeners are registered")
+	UpgradeInProgressError   = errors.New("upgrade: an upgrade is already in progress")
+	ChildExitedError         = errors.New("upgrade: new process exited before becoming ready")
+	ReadyTimeoutError        = errors.New("upgrade: timed out waiting for new process to become ready")
+	UnsupportedListenerError = errors.New("upgrade: listener doesn't support File()")
+)
+
+var logger = logging.For("upgrade")
+
+// filer is implemented by *net.TCPListener and *net.UnixListener.
+type filer interface {
+	File() (*os.File, error)
+}
+
+type registration struct {
+	name     string
+	listener net.Listener
+}
+
+var (
+	registry     []registration
+	registryLock sync.Mutex
+	upgrading    bool
+)
+
+// Register adds a listener to those passed to the new process, under a name
+// the new process will look it up by, typically the configured address.
+// Names need not be unique; they are matched up in registration order.
+func Register(name string, l net.Listener) error {
+	if _, ok := l.(filer); !ok {
+		return UnsupportedListenerError
+	}
+	registryLock.Lock()
+	registry = append(registry, registration{name: name, listener: l})
+	registryLock.Unlock()
+	return nil
+}
+
+// Unregister removes a listener, e.g. when its server stops.
+func Unregister(l net.Listener) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	for i, r := range registry {
+		if r.listener == l {
+			registry = append(registry[:i:i], registry[i+1:]...)
+			return
+		}
+	}
+}
+
+// Options configures Upgrade.
+type Options struct {
+	Path         string        // executable to start, os.Executable() if empty.
+	Args         []string      // arguments including the program name, os.Args if nil.
+	Env          []string      // environment, os.Environ() if nil.
+	Dir          string        // working directory, the current one if empty.
+	Stdout       io.Writer     // os.Stdout if nil.
+	Stderr       io.Writer     // os.Stderr if nil.
+	ReadyTimeout time.Duration // DefaultReadyTimeout if 0.
+}
+
+// Upgrade starts the new process with copies of all registered listeners and
+// waits until it calls Ready.  On success the caller should stop serving and
+// exit; the listeners remain open in the new process.  On failure the new
+// process is killed and the caller carries on serving.
+func Upgrade(options Options) (*os.Process, error) {
+	registryLock.Lock()
+	if upgrading {
+		registryLock.Unlock()
+		return nil, UpgradeInProgressError
+	}
+	upgrading = true
+	registrations := append([]registration(nil), registry...)
+	registryLock.Unlock()
+	defer func() {
+		registryLock.Lock()
+		upgrading = false
+		registryLock.Unlock()
+	}()
+
+	if len(registrations) == 0 {
+		return nil, NoListenersError
+	}
+	if err := applyDefaults(&options); err != nil {
+		return nil, err
+	}
+
+	files := make([]*os.File, 0, len(registrations)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	names := make([]string, len(registrations))
+	for i, r := range registrations {
+		f, err := r.listener.(filer).File()
+		if err != nil {
+			return nil, fmt.Errorf("upgrade: listener %q: %s", r.name, err)
+		}
+		files = append(files, f)
+		names[i] = url.QueryEscape(r.name)
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyReader.Close()
+	files = append(files, readyWriter)
+
+	cmd := exec.Command(options.Path)
+	cmd.Args = options.Args
+	cmd.Dir = options.Dir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = options.Stdout
+	cmd.Stderr = options.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(filterEnv(options.Env),
+		EnvFds+"="+strconv.Itoa(len(registrations)),
+		EnvNames+"="+strings.Join(names, ","),
+		EnvReadyFd+"="+strconv.Itoa(listenFdsStart+len(registrations)),
+	)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	// Only the child should hold the write end, so that its exit is noticed.
+	readyWriter.Close()
+	logger.Infof("upgrade: started pid=%v with %v listeners, waiting for it to become ready", cmd.Process.Pid, len(registrations))
+
+	readyChan := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := readyReader.Read(buf); err != nil {
+			readyChan <- ChildExitedError
+			return
+		}
+		readyChan <- nil
+	}()
+	select {
+	case err = <-readyChan:
+	case <-time.After(options.ReadyTimeout):
+		err = ReadyTimeoutError
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+
+	// The sockets now belong to the new process as well, so this process must
+	// not unlink Unix socket files when it closes its copies.
+	for _, r := range registrations {
+		if ul, ok := r.listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	go cmd.Wait() // Reap the child should it exit before this process does.
+	return cmd.Process, nil
+}
+
+func applyDefaults(options *Options) error {
+	if len(options.Path) == 0 {
+		path, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		options.Path = path
+	}
+	if options.Args == nil {
+		options.Args = os.Args
+	}
+	if options.Env == nil {
+		options.Env = os.Environ()
+	}
+	if options.Stdout == nil {
+		options.Stdout = os.Stdout
+	}
+	if options.Stderr == nil {
+		options.Stderr = os.Stderr
+	}
+	if options.ReadyTimeout <= 0 {
+		options.ReadyTimeout = DefaultReadyTimeout
+	}
+	return nil
+}
+
+// filterEnv drops descriptor-passing variables which don't apply to the new
+// process, including systemd's, since its sockets are passed via ours.
+// WATCHDOG_PID names this process, so would stop the new one from sending
+// keep-alives; without it the new process uses WATCHDOG_USEC as is.
+func filterEnv(env []string) []string {
+	filtered := make([]string, 0, len(env))
+	for _, kv := range env {
+		switch strings.SplitN(kv, "=", 2)[0] {
+		case EnvFds, EnvNames, EnvReadyFd, "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", "WATCHDOG_PID":
+			continue
+		}
+		filtered = append(filtered, kv)
+	}
+	return filtered
+}
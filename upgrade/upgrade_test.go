@@ -0,0 +1,153 @@
+package upgrade_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/web"
+	"github.com/gigawattio/web/upgrade"
+)
+
+// childAddrEnv carries the address the parent's WebServer was configured with
+// into the new process, which runs TestUpgradeChild.
+const childAddrEnv = "UPGRADE_TEST_CHILD_ADDR"
+
+func respondWith(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(body))
+	}
+}
+
+// client avoids keep-alives, which would otherwise keep requests going to the
+// old process after it stops listening.
+var client = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+func get(url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestUpgradeChild(t *testing.T) {
+	addr := os.Getenv(childAddrEnv)
+	if len(addr) == 0 {
+		t.Skip("only runs as the new process started by TestUpgrade")
+	}
+	if !upgrade.IsUpgrade() {
+		t.Fatal("Expected IsUpgrade()=true in the new process")
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); len(pid) > 0 {
+		t.Fatalf("Expected WATCHDOG_PID to be dropped but actual=%q", pid)
+	}
+	quit := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", respondWith("child"))
+	mux.HandleFunc("/quit", func(w http.ResponseWriter, req *http.Request) { close(quit) })
+	ws := web.NewWebServer(web.WebServerOptions{Addr: addr, Handler: mux})
+	if err := ws.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Stop()
+	if err := upgrade.Ready(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-quit:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for /quit")
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	const addr = "127.0.0.1:0"
+	ws := web.NewWebServer(web.WebServerOptions{Addr: addr, Handler: respondWith("parent")})
+	if err := ws.Start(); err != nil {
+		t.Fatal(err)
+	}
+	baseUrl, listenAddr := ws.BaseUrl(), ws.Addr().String()
+	if body, err := get(baseUrl); err != nil || body != "parent" {
+		t.Fatalf("Expected body=%q but actual=%q err=%v", "parent", body, err)
+	}
+
+	process, err := upgrade.Upgrade(upgrade.Options{
+		Args:         []string{os.Args[0], "-test.run=^TestUpgradeChild$"},
+		Env:          append(os.Environ(), childAddrEnv+"="+addr, fmt.Sprintf("WATCHDOG_PID=%v", os.Getpid())),
+		Stdout:       ioutil.Discard,
+		Stderr:       ioutil.Discard,
+		ReadyTimeout: 10 * time.Second,
+	})
+	if err != nil {
+		ws.Stop()
+		t.Fatal(err)
+	}
+	if process.Pid == os.Getpid() {
+		t.Errorf("Expected a new process but actual pid=%v", process.Pid)
+	}
+	if err := ws.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same address is now served by the new process.
+	if body, err := get(baseUrl); err != nil || body != "child" {
+		t.Errorf("Expected body=%q but actual=%q err=%v", "child", body, err)
+	}
+	get(baseUrl + "/quit")
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", listenAddr)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the new process to exit")
+		}
+	}
+}
+
+func TestUpgradeChildExits(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := upgrade.Register("test", l); err != nil {
+		t.Fatal(err)
+	}
+	defer upgrade.Unregister(l)
+
+	// A new process which never becomes ready.
+	_, err = upgrade.Upgrade(upgrade.Options{
+		Args:         []string{os.Args[0], "-test.run=^$"},
+		Stdout:       ioutil.Discard,
+		Stderr:       ioutil.Discard,
+		ReadyTimeout: 10 * time.Second,
+	})
+	if err != upgrade.ChildExitedError {
+		t.Errorf("Expected err=%v but actual=%v", upgrade.ChildExitedError, err)
+	}
+}
+
+func TestUpgradeNoListeners(t *testing.T) {
+	if _, err := upgrade.Upgrade(upgrade.Options{}); err != upgrade.NoListenersError {
+		t.Errorf("Expected err=%v but actual=%v", upgrade.NoListenersError, err)
+	}
+}
+
+type fakeListener struct {
+	net.Listener
+}
+
+func TestRegisterUnsupported(t *testing.T) {
+	if err := upgrade.Register("fake", fakeListener{}); err != upgrade.UnsupportedListenerError {
+		t.Errorf("Expected err=%v but actual=%v", upgrade.UnsupportedListenerError, err)
+	}
+}